@@ -2,8 +2,10 @@ package telnet
 
 // Telnet IAC constants
 const (
+	EOR  = byte(239)
 	SE   = byte(240)
 	NOP  = byte(241)
+	DM   = byte(242)
 	BRK  = byte(243)
 	IP   = byte(244)
 	AO   = byte(245)
@@ -0,0 +1,66 @@
+package telnet
+
+// Key identifies a typed attribute stored on a Connection, so that
+// middleware, option handlers and the Handler can share per-connection state
+// such as a username, terminal capabilities or locale. Keys are compared by
+// identity, so each should be created once, usually as a package variable:
+//
+//	var UserKey = telnet.NewKey[string]("user")
+//
+//	UserKey.Set(conn, "bob")
+//	user, ok := UserKey.Get(conn)
+//
+// Attributes share their storage with Session metadata, which is kept under
+// keys of its own, so neither can overwrite the other.
+type Key[T any] struct {
+	name string
+}
+
+// NewKey creates a new attribute Key. The name is only used for debugging.
+func NewKey[T any](name string) *Key[T] {
+	return &Key[T]{name: name}
+}
+
+func (k *Key[T]) String() string {
+	return k.name
+}
+
+// Get returns the value of the attribute on c, if it is set.
+func (k *Key[T]) Get(c *Connection) (T, bool) {
+	v, ok := c.getAttr(k).(T)
+	return v, ok
+}
+
+// Set sets the attribute on c to v.
+func (k *Key[T]) Set(c *Connection, v T) {
+	c.setAttr(k, v)
+}
+
+// Delete removes the attribute from c.
+func (k *Key[T]) Delete(c *Connection) {
+	c.deleteAttr(k)
+}
+
+// getAttr returns the attribute stored under key, or nil.
+func (c *Connection) getAttr(key any) any {
+	c.amu.RLock()
+	defer c.amu.RUnlock()
+	return c.attrs[key]
+}
+
+// setAttr stores v under key.
+func (c *Connection) setAttr(key, v any) {
+	c.amu.Lock()
+	defer c.amu.Unlock()
+	if c.attrs == nil {
+		c.attrs = make(map[any]any)
+	}
+	c.attrs[key] = v
+}
+
+// deleteAttr removes whatever is stored under key.
+func (c *Connection) deleteAttr(key any) {
+	c.amu.Lock()
+	defer c.amu.Unlock()
+	delete(c.attrs, key)
+}
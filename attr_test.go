@@ -0,0 +1,82 @@
+package telnet_test
+
+import (
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/aprice/telnet"
+)
+
+var (
+	userKey  = telnet.NewKey[string]("user")
+	otherKey = telnet.NewKey[string]("user")
+	colsKey  = telnet.NewKey[int]("cols")
+)
+
+func TestConnection_Attributes(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := telnet.NewConnection(server, nil)
+	defer conn.Close()
+
+	if _, ok := userKey.Get(conn); ok {
+		t.Error("Expected unset attribute")
+	}
+	userKey.Set(conn, "bob")
+	colsKey.Set(conn, 80)
+	if v, ok := userKey.Get(conn); !ok || v != "bob" {
+		t.Errorf("Expected %q, got %q, %v", "bob", v, ok)
+	}
+	if v, ok := colsKey.Get(conn); !ok || v != 80 {
+		t.Errorf("Expected 80, got %d, %v", v, ok)
+	}
+	// Keys with the same name are distinct.
+	if _, ok := otherKey.Get(conn); ok {
+		t.Error("Expected keys to be compared by identity")
+	}
+	userKey.Delete(conn)
+	if _, ok := userKey.Get(conn); ok {
+		t.Error("Expected deleted attribute to be unset")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			colsKey.Set(conn, i)
+			colsKey.Get(conn)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestSession_MetadataSharesAttributes(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := telnet.NewConnection(server, nil)
+	defer conn.Close()
+	sess := &telnet.Session{ID: 1, Conn: conn}
+
+	userKey.Set(conn, "bob")
+	sess.Set("user", "alice")
+	if v, ok := userKey.Get(conn); !ok || v != "bob" {
+		t.Errorf("Expected attribute %q, got %q, %v", "bob", v, ok)
+	}
+	if v, ok := sess.Get("user"); !ok || v != "alice" {
+		t.Errorf("Expected metadata %q, got %q, %v", "alice", v, ok)
+	}
+	if meta := sess.Metadata(); !reflect.DeepEqual(map[string]string{"user": "alice"}, meta) {
+		t.Errorf("Expected only metadata, got %v", meta)
+	}
+	// A new Session for the same connection sees the same metadata.
+	if v, _ := (&telnet.Session{Conn: conn}).Get("user"); v != "alice" {
+		t.Errorf("Expected metadata stored on the connection, got %q", v)
+	}
+	sess.Delete("user")
+	if _, ok := sess.Get("user"); ok {
+		t.Error("Expected deleted metadata to be unset")
+	}
+}
@@ -1,6 +1,7 @@
 package telnet
 
 import (
+	"context"
 	"net"
 )
 
@@ -8,10 +9,30 @@ import (
 // in host:port format. Any specified option handlers will be applied to the
 // connection if it is successful.
 func Dial(addr string, options ...Option) (conn *Connection, err error) {
-	c, err := net.Dial("tcp", addr)
+	return DialContext(context.Background(), addr, options...)
+}
+
+// DialContext is like Dial, but ctx bounds both establishing the connection
+// and the initial option offers. Once DialContext returns, ctx no longer
+// affects the connection, though its values are carried over to the
+// connection's Context.
+func DialContext(ctx context.Context, addr string, options ...Option) (conn *Connection, err error) {
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "tcp", addr)
 	if err != nil {
 		return
 	}
-	conn = NewConnection(c, options)
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		c.SetDeadline(aLongTimeAgo)
+		close(interrupted)
+	})
+	conn = newConnection(context.WithoutCancel(ctx), c)
+	conn.negotiate(options)
+	if !stop() {
+		<-interrupted
+		conn.Close()
+		return nil, ctx.Err()
+	}
 	return
 }
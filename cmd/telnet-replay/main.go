@@ -0,0 +1,67 @@
+// Command telnet-replay serves a session recording, in asciicast v2 or ttyrec
+// format, over telnet: each client which connects is shown the recording with
+// its original timing, and then disconnected.
+//
+// Usage:
+//
+//	telnet-replay [-addr :2323] [-speed 1] [-max-idle 2s] recording
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aprice/telnet"
+	"github.com/aprice/telnet/replay"
+)
+
+func main() {
+	addr := flag.String("addr", ":2323", "address to listen on")
+	speed := flag.Float64("speed", 1, "playback speed multiplier")
+	maxIdle := flag.Duration("max-idle", 0, "longest pause between frames, or 0 for no limit")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] recording\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	path := flag.Arg(0)
+	// Fail early on a missing or unrecognized recording.
+	if err := check(path); err != nil {
+		log.Fatal(err)
+	}
+
+	opts := replay.Options{Speed: *speed, MaxIdle: *maxIdle}
+	svr := telnet.NewServer(*addr, telnet.HandleFunc(func(c *telnet.Connection) {
+		log.Printf("Replaying %s to %s", path, c.RemoteAddr())
+		start := time.Now()
+		f, err := os.Open(path)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		defer f.Close()
+		if err := replay.PlayFile(c.Context(), c, f, opts); err != nil {
+			log.Printf("Replay to %s stopped: %v", c.RemoteAddr(), err)
+			return
+		}
+		log.Printf("Replay to %s finished after %v", c.RemoteAddr(), time.Since(start))
+	}))
+	log.Fatal(svr.ListenAndServe())
+}
+
+func check(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = replay.NewSource(f)
+	return err
+}
@@ -1,8 +1,13 @@
 package telnet
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -25,190 +30,569 @@ type Negotiator interface {
 	HandleSB(conn *Connection, body []byte)
 }
 
+// DisableHandler may be implemented by a Negotiator which needs to know when
+// the remote end disables or refuses its option.
+type DisableHandler interface {
+	// HandleWont is called when an IAC WONT command is received for this
+	// option, indicating the remote end will not use, or has stopped using,
+	// the option.
+	HandleWont(conn *Connection)
+	// HandleDont is called when an IAC DONT command is received for this
+	// option, indicating the remote end wants this end not to use the option.
+	HandleDont(conn *Connection)
+}
+
 // Connection to the telnet server. This lightweight TCPConn wrapper handles
 // telnet control sequences transparently in reads and writes, and provides
 // handling of supported options.
+//
+// All writes to a Connection - data, commands and subnegotiations, including
+// those made by option handlers - are safe for concurrent use by multiple
+// goroutines, and are never interleaved with one another. Reads are not safe
+// for concurrent use. Attributes, accessed with Get and Set, are also safe for
+// concurrent use.
 type Connection struct {
-	// The underlying network connection.
+	// The underlying network connection. Writing to it directly bypasses the
+	// serialization of writes done by Connection.
 	net.Conn
 
 	// OptionHandlers handle IAC options; the key is the IAC option code.
 	OptionHandlers map[byte]Negotiator
 
+	// Serializes writes to Conn
+	wmu sync.Mutex
+
 	// Read buffer
 	buf  []byte
 	r, w int // buf read and write positions
 
-	// IAC handling
-	iac    bool
-	cmd    byte
-	option byte
+	// IAC parsing
+	parser Parser
 
 	// Known client wont/dont
 	clientWont map[byte]bool
 	clientDont map[byte]bool
+
+	// Set when the last read stopped at the end of a record.
+	eor bool
+
+	// Set when the session has been ended by negotiation (e.g. LOGOUT); no
+	// further reads are made against the underlying connection.
+	eof bool
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+
+	// When anything, including control sequences, was last received from
+	// the client, and when data was last received, in Unix nanoseconds.
+	received atomic.Int64
+	input    atomic.Int64
+
+	// Set while a TIMING-MARK sent by keepalive awaits its reply.
+	tmPending atomic.Bool
+
+	// Options offered with DO or WILL which await a reply from the peer, and
+	// options whose subnegotiation is awaited; see AwaitNegotiation.
+	pmu      sync.Mutex
+	pending  map[byte]bool
+	awaiting map[byte]bool
+
+	// Data read while awaiting negotiation, returned before anything else.
+	held []heldData
+
+	// The read and write deadlines last set with SetReadDeadline,
+	// SetWriteDeadline or SetDeadline, in Unix nanoseconds, or zero for none.
+	readDeadline  atomic.Int64
+	writeDeadline atomic.Int64
+
+	// Receives every control sequence sent or received, if set.
+	tracer Tracer
+	// Receives counts of bytes and negotiations, if set.
+	metrics Metrics
+
+	// Attributes set with Set, keyed by *Key[T].
+	amu   sync.RWMutex
+	attrs map[any]any
+
+	// Lifecycle state, reported to stateHook as it changes.
+	smu       sync.Mutex
+	state     ConnState
+	stateHook func(*Connection, ConnState)
 }
 
 // NewConnection initializes a new Connection for this given TCPConn. It will
 // register all the given Option handlers and call Offer() on each, in order.
+// Use NewStreamConnection for transports which are not a net.Conn.
 func NewConnection(c net.Conn, options []Option) *Connection {
+	conn := newConnection(context.Background(), c)
+	conn.negotiate(options)
+	return conn
+}
+
+// newConnection initializes a new Connection whose Context is derived from
+// ctx, with no options.
+func newConnection(ctx context.Context, c net.Conn) *Connection {
 	conn := &Connection{
 		Conn:           c,
-		OptionHandlers: make(map[byte]Negotiator, len(options)),
+		OptionHandlers: make(map[byte]Negotiator),
 		buf:            make([]byte, 256),
 		clientWont:     make(map[byte]bool),
 		clientDont:     make(map[byte]bool),
+		pending:        make(map[byte]bool),
+		awaiting:       make(map[byte]bool),
 	}
+	conn.ctx, conn.cancel = context.WithCancelCause(ctx)
+	now := time.Now().UnixNano()
+	conn.received.Store(now)
+	conn.input.Store(now)
+	return conn
+}
+
+// negotiate registers the given Option handlers and then calls Offer() on
+// each, in order. Options which return a nil Negotiator, such as TraceOption,
+// only configure the connection.
+func (c *Connection) negotiate(options []Option) {
+	handlers := make([]Negotiator, 0, len(options))
 	for _, o := range options {
-		h := o(conn)
-		conn.OptionHandlers[h.OptionCode()] = h
-		h.Offer(conn)
+		if h := o(c); h != nil {
+			c.OptionHandlers[h.OptionCode()] = h
+			handlers = append(handlers, h)
+		}
+	}
+	for _, h := range handlers {
+		h.Offer(c)
 	}
-	return conn
 }
 
-// Write to the connection, escaping IAC as necessary.
+// Write to the connection, escaping IAC as necessary. The escaped data is
+// written in a single write.
 func (c *Connection) Write(b []byte) (n int, err error) {
-	var nn, lastWrite int
-	for i, ch := range b {
+	if bytes.IndexByte(b, IAC) < 0 {
+		return c.write(b)
+	}
+	nn, err := c.write(appendEscaped(make([]byte, 0, len(b)+8), b))
+	if err == nil {
+		return len(b), nil
+	}
+	// Report how much of b was written, rather than how much escaped data.
+	for _, ch := range b {
 		if ch == IAC {
-			if lastWrite < i-1 {
-				nn, err = c.Conn.Write(b[lastWrite:i])
-				n += nn
-				if err != nil {
-					return
-				}
-			}
-			lastWrite = i + 1
-			nn, err = c.Conn.Write([]byte{IAC, IAC})
-			n += nn
-			if err != nil {
-				return
-			}
+			nn -= 2
+		} else {
+			nn--
 		}
+		if nn < 0 {
+			break
+		}
+		n++
 	}
-	if lastWrite < len(b) {
-		nn, err = c.Conn.Write(b[lastWrite:])
-		n += nn
+	return n, err
+}
+
+// write sends a complete frame to the underlying connection. Every write goes
+// through here, so that frames from concurrent writers are never interleaved.
+func (c *Connection) write(frame []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	n, err := c.Conn.Write(frame)
+	if c.metrics != nil && n > 0 {
+		c.metrics.BytesWritten(n)
 	}
-	return
+	return n, err
+}
+
+// WriteCommand writes the negotiation command `IAC <cmd> <option>` to the
+// connection, e.g. WriteCommand(DO, NAWS), in a single write.
+func (c *Connection) WriteCommand(cmd, option byte) error {
+	if cmd == DO || cmd == WILL {
+		c.pmu.Lock()
+		c.pending[option] = true
+		c.pmu.Unlock()
+	}
+	c.trace(Sent, Event{Command: cmd, Option: option})
+	_, err := c.write([]byte{IAC, cmd, option})
+	return err
+}
+
+// WriteSubnegotiation writes the subnegotiation `IAC SB <option> <body> IAC
+// SE` to the connection, escaping any IAC in body. The complete frame is
+// written in a single write, so it cannot be split by other writes.
+func (c *Connection) WriteSubnegotiation(option byte, body []byte) error {
+	frame := make([]byte, 0, len(body)+5)
+	frame = append(frame, IAC, SB, option)
+	frame = appendEscaped(frame, body)
+	frame = append(frame, IAC, SE)
+	c.trace(Sent, Event{Command: SB, Option: option, Body: body})
+	_, err := c.write(frame)
+	return err
+}
+
+// appendEscaped appends b to dst, doubling any IAC.
+func appendEscaped(dst, b []byte) []byte {
+	for _, ch := range b {
+		if ch == IAC {
+			dst = append(dst, IAC)
+		}
+		dst = append(dst, ch)
+	}
+	return dst
 }
 
 // RawWrite writes raw data to the connection, without escaping done by Write.
 // Use of RawWrite over Conn.Write allows Connection to do any additional
 // handling necessary, so long as it does not modify the raw data sent.
+//
+// Any complete control sequences in b are traced.
 func (c *Connection) RawWrite(b []byte) (n int, err error) {
-	return c.Conn.Write(b)
+	if c.tracer != nil {
+		c.traceRaw(b)
+	}
+	return c.write(b)
 }
 
-const maxReadAttempts = 10
-
 // Read from the connection, transparently removing and handling IAC control
-// sequences. It may attempt multiple reads against the underlying connection if
-// it receives back only IAC which gets stripped out of the stream.
+// sequences. Read blocks until at least one byte of data is available or an
+// error occurs, making as many reads against the underlying connection as
+// needed to get past control sequences; it never returns 0, nil unless b is
+// empty. Deadlines set on the underlying connection with SetReadDeadline are
+// honored. Once the session has been ended by negotiation, Read returns io.EOF
+// after any data already received has been consumed.
 func (c *Connection) Read(b []byte) (n int, err error) {
-	for i := 0; i < maxReadAttempts && n == 0 && len(b) > 0; i++ {
+	for n == 0 && err == nil && len(b) > 0 {
 		n, err = c.read(b)
+		c.eor = false
 	}
 	return
 }
 
-func (c *Connection) read(b []byte) (n int, err error) {
-	err = c.fill(len(b))
-	if err != nil {
-		return
+// ReadRecord reads a single record from the connection, as delimited by IAC
+// EOR, transparently removing and handling IAC control sequences. It is used
+// by options such as TN3270E which exchange data in records. Mixing calls to
+// ReadRecord and Read may split records unpredictably.
+func (c *Connection) ReadRecord() ([]byte, error) {
+	var rec []byte
+	b := make([]byte, len(c.buf))
+	for {
+		n, err := c.read(b)
+		rec = append(rec, b[:n]...)
+		if c.eor {
+			c.eor = false
+			return rec, nil
+		}
+		if err != nil {
+			return rec, err
+		}
 	}
-	var lastWrite, subStart int
-	var ignoreIAC bool
-	write := func(end int) int {
-		if c.r == end {
-			return 0
-		}
-		nn := copy(b[lastWrite:], c.buf[c.r:end])
-		n += nn
-		lastWrite += nn
-		c.r += nn
-		return nn
-	}
-	endIAC := func(i int) {
-		subStart = 0
-		c.iac = false
-		c.cmd = 0
-		c.option = 0
-		c.r = i + 1
-	}
-	for i := c.r; i < c.w && lastWrite < len(b); i++ {
-		ch := c.buf[i]
-		if ch == IAC && !ignoreIAC {
-			if c.iac && c.cmd == 0 {
-				// Escaped IAC in text
-				write(i)
-				c.r++
-				c.iac = false
-				continue
-			} else if c.iac && c.buf[i-1] == IAC {
-				// Escaped IAC inside IAC sequence
-				copy(c.buf[:i], c.buf[i+1:])
-				i--
-				ignoreIAC = true
-				continue
-			} else if !c.iac {
-				// Start of IAC sequence
-				write(i)
-				c.iac = true
-				continue
-			}
+}
+
+// WriteRecord writes b to the connection as a single record, escaping IAC as
+// necessary and terminating it with IAC EOR. The complete record is written in
+// a single write.
+func (c *Connection) WriteRecord(b []byte) (n int, err error) {
+	rec := make([]byte, 0, len(b)+2)
+	rec = appendEscaped(rec, b)
+	rec = append(rec, IAC, EOR)
+	c.trace(Sent, Event{Command: EOR})
+	return c.write(rec)
+}
+
+// aLongTimeAgo is a deadline in the past, used to interrupt blocked I/O.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// ReadContext is like Read, but gives up if ctx is done before data is
+// available, returning ctx.Err(). The read is interrupted by setting a read
+// deadline in the past on the underlying connection, after which any deadline
+// set with SetReadDeadline is restored; ReadContext therefore cannot
+// interrupt reads from streams which don't support deadlines.
+func (c *Connection) ReadContext(ctx context.Context, b []byte) (n int, err error) {
+	if err = ctx.Err(); err != nil {
+		return 0, err
+	}
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		c.Conn.SetReadDeadline(aLongTimeAgo)
+		close(interrupted)
+	})
+	n, err = c.Read(b)
+	if !stop() {
+		<-interrupted
+		c.Conn.SetReadDeadline(loadDeadline(&c.readDeadline))
+		if err != nil {
+			err = ctx.Err()
 		}
+	}
+	return
+}
 
-		ignoreIAC = false
+// WriteContext is like Write, but gives up if ctx is done before the write
+// completes, returning ctx.Err(). As with ReadContext, any deadline set with
+// SetWriteDeadline is restored afterwards.
+func (c *Connection) WriteContext(ctx context.Context, b []byte) (n int, err error) {
+	if err = ctx.Err(); err != nil {
+		return 0, err
+	}
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		c.Conn.SetWriteDeadline(aLongTimeAgo)
+		close(interrupted)
+	})
+	n, err = c.Write(b)
+	if !stop() {
+		<-interrupted
+		c.Conn.SetWriteDeadline(loadDeadline(&c.writeDeadline))
+		if err != nil {
+			err = ctx.Err()
+		}
+	}
+	return
+}
 
-		if c.iac && c.cmd == 0 {
-			c.cmd = ch
-			if ch == SB {
-				subStart = i + 2
-			}
-			continue
-		} else if c.iac && c.option == 0 {
-			c.option = ch
-			if c.cmd != SB {
-				c.handleNegotiation()
-				endIAC(i)
+// Context returns the connection's context. It is cancelled when the
+// connection is closed, when Read reaches the end of the session or fails
+// with any error other than a timeout, when logout is agreed, or when the
+// Server which accepted the connection is stopped. For read errors other than
+// io.EOF, and for logout, context.Cause reports why.
+func (c *Connection) Context() context.Context {
+	return c.ctx
+}
+
+// Close the connection, cancelling its Context.
+func (c *Connection) Close() error {
+	c.cancel(nil)
+	return c.Conn.Close()
+}
+
+// read parses whatever is buffered into b, first blocking on the underlying
+// connection if nothing is buffered. It may return 0, nil if everything
+// buffered was a control sequence.
+func (c *Connection) read(b []byte) (n int, err error) {
+	if len(c.held) > 0 {
+		h := &c.held[0]
+		n = copy(b, h.data)
+		h.data = h.data[n:]
+		if len(h.data) == 0 {
+			c.eor = h.eor
+			c.held = c.held[1:]
+		}
+		return n, nil
+	}
+	if c.r == c.w {
+		if c.eof {
+			c.cancel(nil)
+			return 0, io.EOF
+		}
+		if err = c.fill(len(b)); err != nil {
+			// Timeouts may be retried; anything else ends the session.
+			if err == io.EOF {
+				c.cancel(nil)
+			} else if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+				c.cancel(err)
 			}
+			return 0, err
+		}
+	}
+	return c.parse(b), nil
+}
+
+// parse consumes buffered data, handling control sequences and copying data
+// into b, until the buffer is empty, b is full, or the end of a record is
+// reached.
+func (c *Connection) parse(b []byte) (n int) {
+	for c.r < c.w && n < len(b) {
+		nd, ns, ev := c.parser.Parse(b[n:], c.buf[c.r:c.w])
+		n += nd
+		c.r += ns
+		if nd > 0 {
+			c.input.Store(time.Now().UnixNano())
+		}
+		if ev == nil {
 			continue
-		} else if c.iac && c.cmd == SB && ch == SE && c.buf[i-1] == IAC {
-			if h, ok := c.OptionHandlers[c.option]; ok {
-				h.HandleSB(c, c.buf[subStart:i-1])
+		}
+		c.trace(Received, *ev)
+		switch ev.Command {
+		case WILL, WONT, DO, DONT:
+			c.handleNegotiation(ev.Command, ev.Option)
+		case SB:
+			// Truncated bodies are dropped rather than handed to an option
+			// handler which may misinterpret them.
+			c.pmu.Lock()
+			delete(c.awaiting, ev.Option)
+			c.pmu.Unlock()
+			if h, ok := c.OptionHandlers[ev.Option]; ok && !ev.Truncated {
+				h.HandleSB(c, ev.Body)
 			}
-			endIAC(i)
-			continue
+		case EOR:
+			// End of record; stop here so that data from the next record
+			// is not returned along with this one.
+			c.eor = true
+			return
+		default:
+			// Other commands which take no option are ignored.
 		}
 	}
-
-	nn := copy(b[lastWrite:], c.buf[c.r:c.w])
-	n += nn
-	c.r += nn
 	return
 }
 
+// fill blocks until more data is read from the underlying connection. It must
+// only be called once all buffered data has been parsed.
 func (c *Connection) fill(requestedBytes int) error {
-	if c.r > 0 {
-		copy(c.buf, c.buf[c.r:])
-		c.w -= c.r
-		c.r = 0
+	if len(c.buf) < requestedBytes {
+		c.buf = make([]byte, requestedBytes)
+	}
+	// Reads made while the handler is running mark it idle until data
+	// arrives; reads made during negotiation do not.
+	state := c.getState()
+	waiting := state == StateActive || state == StateIdle
+	for {
+		if waiting {
+			c.setState(StateIdle)
+		}
+		nn, err := c.Conn.Read(c.buf)
+		c.r, c.w = 0, nn
+		if waiting {
+			c.setState(StateActive)
+		}
+		if nn > 0 {
+			c.received.Store(time.Now().UnixNano())
+			if c.metrics != nil {
+				c.metrics.BytesRead(nn)
+			}
+			// Any error will recur on the next read, after this data has
+			// been consumed.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
 	}
+}
 
-	if len(c.buf) < requestedBytes {
-		buf := make([]byte, requestedBytes)
-		c.w = copy(buf, c.buf[c.r:c.w])
-		c.r = 0
-		c.buf = buf
+// lastReceived returns when anything, including control sequences, was last
+// received from the client, or when the connection was opened if nothing has
+// been received.
+func (c *Connection) lastReceived() time.Time {
+	return time.Unix(0, c.received.Load())
+}
+
+// lastInput returns when data was last received from the client, ignoring
+// control sequences, or when the connection was opened if no data has been
+// received.
+func (c *Connection) lastInput() time.Time {
+	return time.Unix(0, c.input.Load())
+}
+
+// AwaitSubnegotiation marks option as awaiting a subnegotiation from the peer,
+// so that AwaitNegotiation waits for it. Negotiators call it when they expect
+// the peer to follow up with a subnegotiation, for example after sending
+// `IAC SB <option> SEND IAC SE`, or on receiving WILL NAWS.
+func (c *Connection) AwaitSubnegotiation(option byte) {
+	c.pmu.Lock()
+	c.awaiting[option] = true
+	c.pmu.Unlock()
+}
+
+// heldData is data read while awaiting negotiation, which ends a record if eor
+// is set.
+type heldData struct {
+	data []byte
+	eor  bool
+}
+
+// AwaitNegotiation reads from the connection until every option offered with
+// DO or WILL has been accepted or refused by the peer, and every awaited
+// subnegotiation has arrived, or until timeout elapses or any read deadline
+// set on the connection passes. It reports whether negotiation settled in
+// time. Any data received meanwhile is kept, along with its record
+// boundaries, to be returned by Read or ReadRecord, and the read deadline is
+// restored afterwards. The timeout relies on read deadlines, so on a stream
+// which doesn't support them nothing is read, and AwaitNegotiation just
+// reports whether negotiation has already settled. It is called by a Server with a NegotiationTimeout
+// before calling the Handler, so the Handler sees a stable view of its
+// options.
+func (c *Connection) AwaitNegotiation(timeout time.Duration) bool {
+	prior := loadDeadline(&c.readDeadline)
+	deadline := time.Now().Add(timeout)
+	if !prior.IsZero() && prior.Before(deadline) {
+		deadline = prior
+	}
+	if err := c.Conn.SetReadDeadline(deadline); err != nil {
+		return c.settled()
+	}
+	defer c.Conn.SetReadDeadline(prior)
+	// Set held data aside, so that reads parse more from the connection.
+	held := c.held
+	c.held = nil
+	defer func() { c.held = held }()
+	b := make([]byte, len(c.buf))
+	for !c.settled() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		n, err := c.read(b)
+		if n > 0 || c.eor {
+			if last := len(held) - 1; last >= 0 && !held[last].eor {
+				held[last].data = append(held[last].data, b[:n]...)
+			} else {
+				held = append(held, heldData{data: append([]byte(nil), b[:n]...)})
+			}
+			held[len(held)-1].eor = c.eor
+			c.eor = false
+		}
+		if err != nil {
+			return c.settled()
+		}
 	}
+	return true
+}
 
-	c.SetReadDeadline(time.Now().Add(time.Microsecond))
-	nn, err := c.Conn.Read(c.buf[c.w:])
-	c.SetReadDeadline(time.Time{})
-	c.w += nn
-	return err
+func (c *Connection) settled() bool {
+	c.pmu.Lock()
+	defer c.pmu.Unlock()
+	return len(c.pending) == 0 && len(c.awaiting) == 0
+}
+
+// SetDeadline sets the read and write deadlines of the underlying connection.
+func (c *Connection) SetDeadline(t time.Time) error {
+	storeDeadline(&c.readDeadline, t)
+	storeDeadline(&c.writeDeadline, t)
+	return c.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline of the underlying connection.
+func (c *Connection) SetReadDeadline(t time.Time) error {
+	storeDeadline(&c.readDeadline, t)
+	return c.Conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of the underlying connection.
+func (c *Connection) SetWriteDeadline(t time.Time) error {
+	storeDeadline(&c.writeDeadline, t)
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// storeDeadline records t in v, so that it can be restored after reads or
+// writes which set deadlines of their own.
+func storeDeadline(v *atomic.Int64, t time.Time) {
+	if t.IsZero() {
+		v.Store(0)
+	} else {
+		v.Store(t.UnixNano())
+	}
+}
+
+// loadDeadline returns the deadline recorded in v.
+func loadDeadline(v *atomic.Int64) time.Time {
+	if ns := v.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+// SetMaxSubnegotiation sets the longest subnegotiation body which will be
+// passed to option handlers; longer subnegotiations are dropped. The default
+// is DefaultMaxSubnegotiation.
+func (c *Connection) SetMaxSubnegotiation(n int) {
+	c.parser.MaxSubnegotiation = n
 }
 
 // SetWindowTitle attempts to set the client's telnet window title. Clients may
@@ -217,23 +601,44 @@ func (c *Connection) SetWindowTitle(title string) {
 	fmt.Fprintf(c, TitleBarFmt, title)
 }
 
-func (c *Connection) handleNegotiation() {
-	switch c.cmd {
+func (c *Connection) handleNegotiation(cmd, option byte) {
+	// Any reply settles an offer of the option, and any reply the handler
+	// makes to the peer's own offer is not itself answered. A subnegotiation
+	// the handler awaits is tracked separately, and still holds it open.
+	defer func() {
+		c.pmu.Lock()
+		delete(c.pending, option)
+		c.pmu.Unlock()
+	}()
+	if c.metrics != nil {
+		c.metrics.OptionNegotiated(cmd, option)
+	}
+	if option == TM && (cmd == WILL || cmd == WONT) && c.tmPending.CompareAndSwap(true, false) {
+		// Reply to a keepalive TIMING-MARK; receiving it was all that mattered.
+		return
+	}
+	switch cmd {
 	case WILL:
-		if h, ok := c.OptionHandlers[c.option]; ok {
+		if h, ok := c.OptionHandlers[option]; ok {
 			h.HandleWill(c)
 		} else {
-			c.Conn.Write([]byte{IAC, DONT, c.option})
+			c.WriteCommand(DONT, option)
 		}
 	case WONT:
-		c.clientWont[c.option] = true
+		c.clientWont[option] = true
+		if h, ok := c.OptionHandlers[option].(DisableHandler); ok {
+			h.HandleWont(c)
+		}
 	case DO:
-		if h, ok := c.OptionHandlers[c.option]; ok {
+		if h, ok := c.OptionHandlers[option]; ok {
 			h.HandleDo(c)
 		} else {
-			c.Conn.Write([]byte{IAC, WONT, c.option})
+			c.WriteCommand(WONT, option)
 		}
 	case DONT:
-		c.clientDont[c.option] = true
+		c.clientDont[option] = true
+		if h, ok := c.OptionHandlers[option].(DisableHandler); ok {
+			h.HandleDont(c)
+		}
 	}
 }
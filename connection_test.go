@@ -2,8 +2,13 @@ package telnet_test
 
 import (
 	"bytes"
+	"errors"
+	"io"
 	"net"
+	"os"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/aprice/telnet"
 )
@@ -52,6 +57,109 @@ func TestConnection_Write(t *testing.T) {
 	}
 }
 
+func TestConnection_WriteSubnegotiation(t *testing.T) {
+	tests := []struct {
+		name     string
+		option   byte
+		body     []byte
+		expected []byte
+	}{
+		{
+			name:     "plain",
+			option:   telnet.TTYPE,
+			body:     []byte{telnet.SEND},
+			expected: []byte{255, 250, 24, 1, 255, 240},
+		},
+		{
+			name:     "iac",
+			option:   telnet.NAWS,
+			body:     []byte{0, 255, 255, 0},
+			expected: []byte{255, 250, 31, 0, 255, 255, 255, 255, 0, 255, 240},
+		},
+		{
+			name:     "empty",
+			option:   telnet.SNDLOC,
+			expected: []byte{255, 250, 23, 255, 240},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sc := newScriptConn()
+			conn := telnet.NewConnection(sc, nil)
+			if err := conn.WriteSubnegotiation(test.option, test.body); err != nil {
+				t.Error(err)
+			}
+			if !bytes.Equal(test.expected, sc.out.Bytes()) {
+				t.Errorf("Expected %v, got %v", test.expected, sc.out.Bytes())
+			}
+			if sc.writes != 1 {
+				t.Errorf("Expected a single write, got %d", sc.writes)
+			}
+		})
+	}
+}
+
+func TestConnection_WriteCommand(t *testing.T) {
+	sc := newScriptConn()
+	conn := telnet.NewConnection(sc, nil)
+	if err := conn.WriteCommand(telnet.DO, telnet.NAWS); err != nil {
+		t.Error(err)
+	}
+	if !bytes.Equal([]byte{255, 253, 31}, sc.out.Bytes()) || sc.writes != 1 {
+		t.Errorf("Expected IAC DO NAWS in a single write, got %v in %d", sc.out.Bytes(), sc.writes)
+	}
+}
+
+func TestConnection_ConcurrentWrites(t *testing.T) {
+	const writers, rounds = 8, 50
+	client, server := net.Pipe()
+	conn := telnet.NewConnection(server, nil)
+	wg := new(sync.WaitGroup)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(id byte) {
+			defer wg.Done()
+			for j := 0; j < rounds; j++ {
+				if _, err := conn.Write([]byte{'a' + id, 255, 'z' + id, '\n'}); err != nil {
+					t.Error(err)
+				}
+				if err := conn.WriteSubnegotiation(telnet.NAWS, []byte{id, 255, id, 255}); err != nil {
+					t.Error(err)
+				}
+				if err := conn.WriteCommand(telnet.WILL, id); err != nil {
+					t.Error(err)
+				}
+			}
+		}(byte(i))
+	}
+	go func() {
+		wg.Wait()
+		conn.Close()
+	}()
+	b, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every frame must arrive intact; strip them all out and nothing should
+	// remain.
+	for i := byte(0); i < writers; i++ {
+		frames := [][]byte{
+			{'a' + i, 255, 255, 'z' + i, '\n'},
+			{255, 250, 31, i, 255, 255, i, 255, 255, 255, 240},
+			{255, 251, i},
+		}
+		for _, frame := range frames {
+			if c := bytes.Count(b, frame); c != rounds {
+				t.Errorf("Expected %d of frame %v, got %d", rounds, frame, c)
+			}
+			b = bytes.ReplaceAll(b, frame, nil)
+		}
+	}
+	if len(b) != 0 {
+		t.Errorf("Unexpected interleaved output %v", b)
+	}
+}
+
 func TestConnection_Read(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -92,6 +200,72 @@ func TestConnection_Read(t *testing.T) {
 	}
 }
 
+func TestConnection_ReadBlocks(t *testing.T) {
+	client, server := net.Pipe()
+	go func() {
+		// IAC NOP, then data once the reader has had a chance to give up
+		client.Write([]byte{255, 241})
+		time.Sleep(20 * time.Millisecond)
+		client.Write([]byte("hi"))
+		client.Close()
+	}()
+	conn := telnet.NewConnection(server, nil)
+	b := make([]byte, 8)
+	n, err := conn.Read(b)
+	if err != nil {
+		t.Error(err)
+	}
+	if string(b[:n]) != "hi" {
+		t.Errorf("Expected %q, got %q", "hi", b[:n])
+	}
+	n, err = conn.Read(b)
+	if n != 0 || err != io.EOF {
+		t.Errorf("Expected 0, EOF, got %d, %v", n, err)
+	}
+}
+
+func TestConnection_ReadDeadline(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := telnet.NewConnection(server, nil)
+	conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	b := make([]byte, 8)
+	n, err := conn.Read(b)
+	if n != 0 || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Expected 0, deadline exceeded, got %d, %v", n, err)
+	}
+
+	conn.SetReadDeadline(time.Time{})
+	go client.Write([]byte{255, 241, 'o', 'k'})
+	n, err = conn.Read(b)
+	if err != nil {
+		t.Error(err)
+	}
+	if string(b[:n]) != "ok" {
+		t.Errorf("Expected %q, got %q", "ok", b[:n])
+	}
+}
+
+// scriptConn is a net.Conn which replays scripted input and captures output,
+// allowing negotiation to be tested without a live peer.
+type scriptConn struct {
+	net.Conn
+	in     *bytes.Reader
+	out    bytes.Buffer
+	writes int
+}
+
+func newScriptConn(input ...[]byte) *scriptConn {
+	return &scriptConn{in: bytes.NewReader(bytes.Join(input, nil))}
+}
+
+func (s *scriptConn) Read(b []byte) (int, error)         { return s.in.Read(b) }
+func (s *scriptConn) Write(b []byte) (int, error)        { s.writes++; return s.out.Write(b) }
+func (s *scriptConn) Close() error                       { return nil }
+func (s *scriptConn) SetReadDeadline(t time.Time) error  { return nil }
+func (s *scriptConn) SetWriteDeadline(t time.Time) error { return nil }
+func (s *scriptConn) SetDeadline(t time.Time) error      { return nil }
+
 type closerBuf struct {
 	*bytes.Buffer
 }
@@ -0,0 +1,170 @@
+package telnet_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aprice/telnet"
+)
+
+func TestConnection_Context(t *testing.T) {
+	client, server := net.Pipe()
+	conn := telnet.NewConnection(server, nil)
+	if conn.Context().Err() != nil {
+		t.Error("Expected context to be live before close")
+	}
+	conn.Close()
+	if conn.Context().Err() != context.Canceled {
+		t.Errorf("Expected context to be cancelled after close, got %v", conn.Context().Err())
+	}
+	client.Close()
+
+	client, server = net.Pipe()
+	conn = telnet.NewConnection(server, nil)
+	client.Close()
+	if _, err := conn.Read(make([]byte, 8)); err != io.EOF {
+		t.Errorf("Expected EOF, got %v", err)
+	}
+	if conn.Context().Err() != context.Canceled {
+		t.Errorf("Expected context to be cancelled after EOF, got %v", conn.Context().Err())
+	}
+}
+
+// failingConn is a net.Conn whose reads fail with err.
+type failingConn struct {
+	net.Conn
+	err error
+}
+
+func (c failingConn) Read(b []byte) (int, error) {
+	return 0, c.err
+}
+
+func TestConnection_ContextReadError(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	reset := errors.New("connection reset")
+	conn := telnet.NewConnection(failingConn{Conn: server, err: reset}, nil)
+	defer conn.Close()
+	if _, err := conn.Read(make([]byte, 8)); err != reset {
+		t.Errorf("Expected read error, got %v", err)
+	}
+	if cause := context.Cause(conn.Context()); cause != reset {
+		t.Errorf("Expected context cancelled by read error, got %v", cause)
+	}
+}
+
+func TestConnection_ContextRestoresDeadlines(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := telnet.NewConnection(server, nil)
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(150 * time.Millisecond))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := conn.ReadContext(ctx, make([]byte, 8)); err != context.DeadlineExceeded {
+		t.Errorf("Expected ReadContext to be interrupted, got %v", err)
+	}
+	if _, err := conn.WriteContext(ctx, []byte("unread")); err != context.DeadlineExceeded {
+		t.Errorf("Expected WriteContext to be interrupted, got %v", err)
+	}
+	// The deadlines set beforehand still apply.
+	start := time.Now()
+	if _, err := conn.Read(make([]byte, 8)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Expected read deadline to be restored, got %v", err)
+	}
+	if _, err := conn.Write([]byte("unread")); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Expected write deadline to be restored, got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Expected deadlines to expire, took %v", d)
+	}
+	if conn.Context().Err() != nil {
+		t.Error("Expected timeouts not to cancel the context")
+	}
+}
+
+func TestConnection_ReadContext(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := telnet.NewConnection(server, nil)
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	b := make([]byte, 8)
+	n, err := conn.ReadContext(ctx, b)
+	if n != 0 || err != context.Canceled {
+		t.Errorf("Expected 0, context.Canceled, got %d, %v", n, err)
+	}
+
+	// The connection is still usable afterward.
+	go client.Write([]byte("hi"))
+	n, err = conn.ReadContext(context.Background(), b)
+	if err != nil {
+		t.Error(err)
+	}
+	if string(b[:n]) != "hi" {
+		t.Errorf("Expected %q, got %q", "hi", b[:n])
+	}
+}
+
+func TestConnection_WriteContext(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := telnet.NewConnection(server, nil)
+	defer conn.Close()
+
+	// Nothing reads from client, so the write blocks.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := conn.WriteContext(ctx, []byte("hello"))
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestDialContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := telnet.DialContext(ctx, "127.0.0.1:1"); err == nil {
+		t.Error("Expected error dialing with cancelled context")
+	}
+
+	started := make(chan struct{})
+	done := make(chan error, 1)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := telnet.NewServer("", telnet.HandleFunc(func(c *telnet.Connection) {
+		close(started)
+		select {
+		case <-c.Context().Done():
+			done <- nil
+		case <-time.After(time.Second):
+			done <- context.DeadlineExceeded
+		}
+	}))
+	go s.Serve(l)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	client, err := telnet.DialContext(ctx, l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	// Stopping the server notifies active handlers through their context.
+	<-started
+	s.Stop()
+	if err := <-done; err != nil {
+		t.Errorf("Expected handler context to be cancelled on Stop, got %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package telnet
+
+import "time"
+
+// DefaultIdleWarningMessage is sent to idle clients by a Server with an
+// IdleWarning but no IdleWarningMessage.
+const DefaultIdleWarningMessage = "\r\nYou have been idle too long and will be disconnected soon.\r\n"
+
+// KeepaliveMode selects how a Connection checks that its peer is still there.
+type KeepaliveMode int
+
+const (
+	// KeepaliveNOP sends IAC NOP, which the peer ignores. It keeps NAT and
+	// firewall state alive, and a dead peer is detected once writes to it
+	// start failing.
+	KeepaliveNOP KeepaliveMode = iota
+	// KeepaliveTimingMark sends IAC DO TIMING-MARK, which the peer must
+	// answer. A peer which sends nothing at all for a whole interval after
+	// being asked is considered dead. The connection must be read for replies
+	// to be seen.
+	KeepaliveTimingMark
+)
+
+// Keepalive sends a keepalive to the peer every interval, in the background,
+// until the connection is closed. If the peer is found to be dead, the
+// connection is closed, which interrupts any blocked Read or Write.
+func (c *Connection) Keepalive(interval time.Duration, mode KeepaliveMode) {
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		var sent time.Time
+		for {
+			select {
+			case <-c.ctx.Done():
+				return
+			case now := <-t.C:
+				if mode == KeepaliveTimingMark && !sent.IsZero() && c.lastReceived().Before(sent) {
+					c.Close()
+					return
+				}
+				var err error
+				if mode == KeepaliveTimingMark {
+					c.tmPending.Store(true)
+					err = c.WriteCommand(DO, TM)
+					sent = now
+				} else {
+					c.trace(Sent, Event{Command: NOP})
+					_, err = c.write([]byte{IAC, NOP})
+				}
+				if err != nil {
+					c.Close()
+					return
+				}
+			}
+		}
+	}()
+}
+
+// watchIdle closes the connection once no data has been received from the
+// client for timeout, until done is closed. If warning is non-zero, msg is
+// written to the client once it has been idle for timeout-warning.
+func (c *Connection) watchIdle(done <-chan struct{}, timeout, warning time.Duration, msg string) {
+	// A warning as long as the timeout would be sent as soon as the
+	// connection was accepted, so it is ignored.
+	if warning >= timeout {
+		warning = 0
+	}
+	warnAt := timeout - warning
+	warned := false
+	t := time.NewTimer(warnAt)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-c.ctx.Done():
+			return
+		case <-t.C:
+		}
+		idle := time.Since(c.lastInput())
+		if idle >= timeout {
+			c.Close()
+			return
+		}
+		if idle < warnAt {
+			warned = false
+			t.Reset(warnAt - idle)
+			continue
+		}
+		if warning > 0 && !warned {
+			warned = true
+			c.Write([]byte(msg))
+		}
+		t.Reset(timeout - idle)
+	}
+}
@@ -0,0 +1,128 @@
+package telnet_test
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aprice/telnet"
+)
+
+func TestConnection_KeepaliveNOP(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := telnet.NewConnection(server, nil)
+	defer conn.Close()
+	conn.Keepalive(10*time.Millisecond, telnet.KeepaliveNOP)
+
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(client, b); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, []byte{telnet.IAC, telnet.NOP}) {
+		t.Errorf("Expected IAC NOP, got %v", b)
+	}
+}
+
+func TestConnection_KeepaliveTimingMark(t *testing.T) {
+	doTM := []byte{telnet.IAC, telnet.DO, telnet.TM}
+
+	// A peer which answers stays connected, and its answers are not
+	// themselves answered.
+	client, server := net.Pipe()
+	conn := telnet.NewConnection(server, nil)
+	conn.Keepalive(10*time.Millisecond, telnet.KeepaliveTimingMark)
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 8))
+		readErr <- err
+	}()
+	for i := 0; i < 5; i++ {
+		b := make([]byte, 3)
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := io.ReadFull(client, b); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, doTM) {
+			t.Fatalf("Expected IAC DO TM, got %v", b)
+		}
+		client.Write([]byte{telnet.IAC, telnet.WILL, telnet.TM})
+	}
+	select {
+	case err := <-readErr:
+		t.Fatalf("Expected live peer to stay connected, got %v", err)
+	default:
+	}
+	conn.Close()
+	client.Close()
+
+	// A peer which never answers is disconnected.
+	client, server = net.Pipe()
+	defer client.Close()
+	conn = telnet.NewConnection(server, nil)
+	conn.Keepalive(10*time.Millisecond, telnet.KeepaliveTimingMark)
+	go io.Copy(io.Discard, client)
+	go func() {
+		_, err := conn.Read(make([]byte, 8))
+		readErr <- err
+	}()
+	select {
+	case err := <-readErr:
+		if err == nil {
+			t.Error("Expected read to fail once peer was found dead")
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected dead peer to be disconnected")
+	}
+}
+
+func TestServer_IdleTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := telnet.NewServer("", telnet.HandleFunc(func(c *telnet.Connection) {
+		io.Copy(io.Discard, c)
+	}))
+	s.IdleTimeout = 100 * time.Millisecond
+	s.IdleWarning = 50 * time.Millisecond
+	go s.Serve(l)
+	defer s.Close()
+
+	start := time.Now()
+	if got := dialAndRead(t, l.Addr().String()); got != telnet.DefaultIdleWarningMessage {
+		t.Errorf("Expected idle warning, got %q", got)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected disconnect after idle timeout, got %v", elapsed)
+	}
+}
+
+func TestServer_IdleWarningTooLong(t *testing.T) {
+	for _, warning := range []time.Duration{100 * time.Millisecond, time.Second} {
+		t.Run(warning.String(), func(t *testing.T) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := telnet.NewServer("", telnet.HandleFunc(func(c *telnet.Connection) {
+				io.Copy(io.Discard, c)
+			}))
+			s.IdleTimeout = 100 * time.Millisecond
+			s.IdleWarning = warning
+			go s.Serve(l)
+			defer s.Close()
+
+			start := time.Now()
+			if got := dialAndRead(t, l.Addr().String()); got != "" {
+				t.Errorf("Expected no idle warning, got %q", got)
+			}
+			if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+				t.Errorf("Expected disconnect after idle timeout, got %v", elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package telnet
+
+import (
+	"errors"
+	"sync"
+)
+
+// REMOTE-FLOW-CONTROL subnegotiation commands, per RFC 1372.
+const (
+	LFLOWOff        = byte(0)
+	LFLOWOn         = byte(1)
+	LFLOWRestartAny = byte(2)
+	LFLOWRestartXON = byte(3)
+)
+
+// ErrOptionDisabled is returned when an option's subnegotiation is attempted
+// before the remote end has agreed to enable the option.
+var ErrOptionDisabled = errors.New("telnet: option not enabled by remote end")
+
+// LFLOWOption enables REMOTE-FLOW-CONTROL (RFC 1372) negotiation on a Server.
+// Once the client agrees, flow control and restart behavior can be toggled
+// through the connection's LFLOWHandler.
+func LFLOWOption(c *Connection) Negotiator {
+	return &LFLOWHandler{conn: c, client: false}
+}
+
+// ExposeLFLOW enables REMOTE-FLOW-CONTROL negotiation on a Client. The
+// server's requested modes are tracked by the connection's LFLOWHandler; it is
+// up to the client to apply them to its local terminal.
+func ExposeLFLOW(c *Connection) Negotiator {
+	return &LFLOWHandler{conn: c, client: true}
+}
+
+// LFLOWHandler negotiates REMOTE-FLOW-CONTROL for a specific connection. Its
+// methods are safe to call from the connection handler while options are being
+// processed by reads on another goroutine.
+type LFLOWHandler struct {
+	conn   *Connection
+	client bool
+
+	mu         sync.Mutex
+	enabled    bool
+	flow       bool
+	restartAny bool
+}
+
+func (l *LFLOWHandler) OptionCode() byte {
+	return LFLOW
+}
+
+func (l *LFLOWHandler) Offer(c *Connection) {
+	if !l.client {
+		c.WriteCommand(DO, l.OptionCode())
+	}
+}
+
+func (l *LFLOWHandler) HandleWill(c *Connection) {
+	if !l.client {
+		l.enable()
+	}
+}
+
+func (l *LFLOWHandler) HandleDo(c *Connection) {
+	if l.client {
+		c.WriteCommand(WILL, l.OptionCode())
+		l.enable()
+	} else {
+		c.WriteCommand(WONT, l.OptionCode())
+	}
+}
+
+func (l *LFLOWHandler) HandleSB(c *Connection, b []byte) {
+	if !l.client || len(b) == 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch b[0] {
+	case LFLOWOff:
+		l.flow = false
+	case LFLOWOn:
+		l.flow = true
+	case LFLOWRestartAny:
+		l.restartAny = true
+	case LFLOWRestartXON:
+		l.restartAny = false
+	}
+}
+
+// HandleWont disables the option on a Server when the client stops, or
+// refuses, flow control.
+func (l *LFLOWHandler) HandleWont(c *Connection) {
+	if !l.client && l.disable() {
+		c.WriteCommand(DONT, l.OptionCode())
+	}
+}
+
+// HandleDont disables the option on a Client when the server asks it to stop.
+func (l *LFLOWHandler) HandleDont(c *Connection) {
+	if l.client && l.disable() {
+		c.WriteCommand(WONT, l.OptionCode())
+	}
+}
+
+// disable clears the negotiated modes, reporting whether the option had been
+// enabled, in which case the change must be acknowledged.
+func (l *LFLOWHandler) disable() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	was := l.enabled
+	l.enabled = false
+	l.flow = false
+	l.restartAny = false
+	return was
+}
+
+// enable marks the option as agreed. Per RFC 1372, flow control starts out
+// enabled; the restart mode is system dependent, so XON-only is assumed.
+func (l *LFLOWHandler) enable() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.enabled {
+		l.enabled = true
+		l.flow = true
+	}
+}
+
+// Enabled reports whether both ends have agreed to REMOTE-FLOW-CONTROL.
+func (l *LFLOWHandler) Enabled() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.enabled
+}
+
+// FlowControl reports whether the client is expected to honor XON/XOFF
+// locally. It is always false if the option has not been enabled.
+func (l *LFLOWHandler) FlowControl() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.enabled && l.flow
+}
+
+// RestartAny reports whether any character, rather than only XON, restarts
+// output after it has been stopped by XOFF.
+func (l *LFLOWHandler) RestartAny() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.enabled && l.restartAny
+}
+
+// SetFlowControl asks the client to enable or disable local XON/XOFF flow
+// control. It may only be called on a Server, after the client has enabled the
+// option.
+func (l *LFLOWHandler) SetFlowControl(on bool) error {
+	cmd := LFLOWOff
+	if on {
+		cmd = LFLOWOn
+	}
+	return l.send(cmd, func() { l.flow = on })
+}
+
+// SetRestartAny asks the client to restart output on any character (true) or
+// only on XON (false). It may only be called on a Server, after the client has
+// enabled the option.
+func (l *LFLOWHandler) SetRestartAny(on bool) error {
+	cmd := LFLOWRestartXON
+	if on {
+		cmd = LFLOWRestartAny
+	}
+	return l.send(cmd, func() { l.restartAny = on })
+}
+
+func (l *LFLOWHandler) send(cmd byte, apply func()) error {
+	if l.client {
+		return errors.New("telnet: LFLOW modes can only be set by the server")
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.enabled {
+		return ErrOptionDisabled
+	}
+	err := l.conn.WriteSubnegotiation(l.OptionCode(), []byte{cmd})
+	if err != nil {
+		return err
+	}
+	apply()
+	return nil
+}
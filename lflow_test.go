@@ -0,0 +1,87 @@
+package telnet_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/aprice/telnet"
+)
+
+func TestServerLFLOW(t *testing.T) {
+	// IAC WILL LFLOW
+	sc := newScriptConn([]byte{255, 251, 33})
+	conn := telnet.NewConnection(sc, []telnet.Option{telnet.LFLOWOption})
+	lf := conn.OptionHandlers[telnet.LFLOW].(*telnet.LFLOWHandler)
+	if err := lf.SetFlowControl(false); err != telnet.ErrOptionDisabled {
+		t.Errorf("Expected ErrOptionDisabled before negotiation, got %v", err)
+	}
+	conn.Read(make([]byte, 32))
+	if !lf.Enabled() || !lf.FlowControl() || lf.RestartAny() {
+		t.Errorf("Expected enabled with flow control and XON restart, got %v %v %v",
+			lf.Enabled(), lf.FlowControl(), lf.RestartAny())
+	}
+	if err := lf.SetFlowControl(false); err != nil {
+		t.Error(err)
+	}
+	if err := lf.SetRestartAny(true); err != nil {
+		t.Error(err)
+	}
+	if lf.FlowControl() || !lf.RestartAny() {
+		t.Errorf("Expected flow control off with any restart, got %v %v", lf.FlowControl(), lf.RestartAny())
+	}
+	// IAC DO LFLOW IAC SB LFLOW OFF IAC SE IAC SB LFLOW RESTART-ANY IAC SE
+	expected := []byte{255, 253, 33, 255, 250, 33, 0, 255, 240, 255, 250, 33, 2, 255, 240}
+	if !bytes.Equal(expected, sc.out.Bytes()) {
+		t.Errorf("Expected %v, received %v", expected, sc.out.Bytes())
+	}
+}
+
+func TestClientLFLOW(t *testing.T) {
+	// IAC DO LFLOW IAC SB LFLOW OFF IAC SE IAC SB LFLOW RESTART-ANY IAC SE
+	sc := newScriptConn([]byte{255, 253, 33, 255, 250, 33, 0, 255, 240, 255, 250, 33, 2, 255, 240})
+	conn := telnet.NewConnection(sc, []telnet.Option{telnet.ExposeLFLOW})
+	conn.Read(make([]byte, 32))
+	if !bytes.Equal([]byte{255, 251, 33}, sc.out.Bytes()) {
+		t.Errorf("Expected IAC WILL LFLOW, received %v", sc.out.Bytes())
+	}
+	lf := conn.OptionHandlers[telnet.LFLOW].(*telnet.LFLOWHandler)
+	if lf.FlowControl() || !lf.RestartAny() {
+		t.Errorf("Expected flow control off with any restart, got %v %v", lf.FlowControl(), lf.RestartAny())
+	}
+	if err := lf.SetFlowControl(true); err == nil {
+		t.Error("Expected error setting flow control from client")
+	}
+}
+
+func TestLFLOWDisable(t *testing.T) {
+	// IAC WILL LFLOW IAC WONT LFLOW
+	sc := newScriptConn([]byte{255, 251, 33}, []byte{255, 252, 33})
+	conn := telnet.NewConnection(sc, []telnet.Option{telnet.LFLOWOption})
+	lf := conn.OptionHandlers[telnet.LFLOW].(*telnet.LFLOWHandler)
+	conn.Read(make([]byte, 32))
+	if lf.Enabled() || lf.FlowControl() {
+		t.Errorf("Expected server LFLOW disabled after WONT, got %v %v", lf.Enabled(), lf.FlowControl())
+	}
+	if err := lf.SetFlowControl(true); err != telnet.ErrOptionDisabled {
+		t.Errorf("Expected ErrOptionDisabled after WONT, got %v", err)
+	}
+	// IAC DO LFLOW IAC DONT LFLOW
+	expected := []byte{255, 253, 33, 255, 254, 33}
+	if !bytes.Equal(expected, sc.out.Bytes()) {
+		t.Errorf("Expected %v, received %v", expected, sc.out.Bytes())
+	}
+
+	// IAC DO LFLOW IAC SB LFLOW RESTART-ANY IAC SE IAC DONT LFLOW
+	sc = newScriptConn([]byte{255, 253, 33, 255, 250, 33, 2, 255, 240, 255, 254, 33})
+	conn = telnet.NewConnection(sc, []telnet.Option{telnet.ExposeLFLOW})
+	lf = conn.OptionHandlers[telnet.LFLOW].(*telnet.LFLOWHandler)
+	conn.Read(make([]byte, 32))
+	if lf.Enabled() || lf.FlowControl() || lf.RestartAny() {
+		t.Errorf("Expected client LFLOW reset after DONT, got %v %v %v", lf.Enabled(), lf.FlowControl(), lf.RestartAny())
+	}
+	// IAC WILL LFLOW IAC WONT LFLOW
+	expected = []byte{255, 251, 33, 255, 252, 33}
+	if !bytes.Equal(expected, sc.out.Bytes()) {
+		t.Errorf("Expected %v, received %v", expected, sc.out.Bytes())
+	}
+}
@@ -0,0 +1,134 @@
+package telnet
+
+import (
+	"net"
+	"time"
+)
+
+// overflowWriteTimeout bounds how long an overflow handler may spend writing
+// to a connection which is about to be refused.
+const overflowWriteTimeout = 5 * time.Second
+
+// maxOverflowHandlers bounds how many refused connections may be passed to the
+// OverflowHandler at once, so that a connection storm cannot exhaust
+// goroutines and file descriptors. Connections refused beyond this are closed
+// straight away.
+const maxOverflowHandlers = 64
+
+// bucketSweepInterval is how often idle per-IP rate limit buckets are
+// discarded.
+const bucketSweepInterval = time.Minute
+
+// DefaultOverflowHandler is used by a Server with no OverflowHandler. It tells
+// the client the server is full before the connection is closed.
+var DefaultOverflowHandler Handler = HandleFunc(func(c *Connection) {
+	c.Write([]byte("Server is full, please try again later.\r\n"))
+})
+
+// bucket is a token bucket limiting the connection rate from one address.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// remoteHost returns the host part of addr, so that connections from the same
+// address are counted together regardless of port.
+func remoteHost(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// admit reports whether a new connection from host may be handled under the
+// Server's limits, and if so counts it against them. Connections admitted
+// must be released with release.
+func (s *Server) admit(host string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.MaxConnections > 0 && s.admitted >= s.MaxConnections {
+		return false
+	}
+	if s.MaxConnectionsPerIP > 0 && s.perIP[host] >= s.MaxConnectionsPerIP {
+		return false
+	}
+	if s.ConnectRate > 0 && !s.takeToken(host, time.Now()) {
+		return false
+	}
+	s.admitted++
+	s.perIP[host]++
+	return true
+}
+
+// release stops counting a connection admitted from host.
+func (s *Server) release(host string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.admitted--
+	if s.perIP[host]--; s.perIP[host] <= 0 {
+		delete(s.perIP, host)
+	}
+}
+
+// takeToken takes a token from host's bucket, refilled at ConnectRate per
+// second up to ConnectBurst, and reports whether one was available. s.mu must
+// be held.
+func (s *Server) takeToken(host string, now time.Time) bool {
+	burst := float64(s.ConnectBurst)
+	if burst < 1 {
+		burst = 1
+	}
+	if now.Sub(s.lastSweep) > bucketSweepInterval {
+		for h, b := range s.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*s.ConnectRate >= burst {
+				delete(s.buckets, h)
+			}
+		}
+		s.lastSweep = now
+	}
+	b, ok := s.buckets[host]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		s.buckets[host] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * s.ConnectRate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// overflow refuses a connection which exceeded the Server's limits, passing it
+// to the OverflowHandler before closing it. No options are negotiated.
+func (s *Server) overflow(c net.Conn) {
+	s.mu.Lock()
+	if s.overflowing >= maxOverflowHandlers {
+		s.mu.Unlock()
+		c.Close()
+		return
+	}
+	s.overflowing++
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.overflowing--
+		s.mu.Unlock()
+	}()
+	h := s.OverflowHandler
+	if h == nil {
+		h = DefaultOverflowHandler
+	}
+	c.SetDeadline(time.Now().Add(overflowWriteTimeout))
+	conn := newConnection(s.ctx, c)
+	h.HandleTelnet(conn)
+	conn.Close()
+}
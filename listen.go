@@ -0,0 +1,109 @@
+package telnet
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket
+// activation.
+const listenFDsStart = 3
+
+// ServeAll runs Serve on each of the given listeners concurrently, for example
+// a plain port, a TLS port and a Unix socket. It does not return until all of
+// them have returned. If any returns an error, the Server is stopped, every
+// listener is closed, and the first such error is returned.
+func (s *Server) ServeAll(listeners ...net.Listener) error {
+	var wg sync.WaitGroup
+	var once sync.Once
+	var first error
+	for _, l := range listeners {
+		wg.Add(1)
+		go func(l net.Listener) {
+			defer wg.Done()
+			if err := s.Serve(l); err != nil {
+				once.Do(func() {
+					first = err
+					s.Stop()
+				})
+			}
+		}(l)
+	}
+	wg.Wait()
+	if first != nil {
+		// Serve does not close a listener whose Accept failed, nor any
+		// which had not yet been registered when the Server was stopped.
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+	return first
+}
+
+// ListenAndServeUnix runs the telnet server on a Unix domain socket at path,
+// as created by ListenUnix.
+func (s *Server) ListenAndServeUnix(path string) error {
+	l, err := ListenUnix(path)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// ListenUnix listens on a Unix domain socket at path. A stale socket left at
+// path by a previous process which is no longer listening is removed first.
+// The socket is removed when the listener is closed.
+func ListenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+		} else {
+			os.Remove(path)
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// SystemdListeners returns the listeners passed to this process by systemd
+// socket activation, in the order they were configured, or nil if there are
+// none. The LISTEN_* environment variables are cleared so that they are not
+// inherited by child processes.
+func SystemdListeners() ([]net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	listeners := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		name := "LISTEN_FD_" + strconv.Itoa(listenFDsStart+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(listenFDsStart+i), name)
+		l, err := net.FileListener(f)
+		// FileListener dups the descriptor, so the original is not needed.
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("telnet: systemd socket %s: %w", name, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,151 @@
+package telnet_test
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aprice/telnet"
+)
+
+func TestServer_ServeAll(t *testing.T) {
+	tcp1, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcp2, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "telnet.sock")
+	unix, err := telnet.ListenUnix(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := telnet.NewServer("", telnet.HandleFunc(func(c *telnet.Connection) {
+		c.Write([]byte("Hello!"))
+	}))
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ServeAll(tcp1, tcp2, unix)
+	}()
+
+	for _, addr := range []string{tcp1.Addr().String(), tcp2.Addr().String()} {
+		if got := dialAndRead(t, addr); got != "Hello!" {
+			t.Errorf("Expected %q from %s, got %q", "Hello!", addr, got)
+		}
+	}
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 6)
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := c.Read(b); err != nil || string(b) != "Hello!" {
+		t.Errorf("Expected %q from unix socket, got %q, %v", "Hello!", b, err)
+	}
+	c.Close()
+
+	s.Stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected nil from ServeAll after Stop, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected ServeAll to return after Stop")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected socket to be removed, got %v", err)
+	}
+}
+
+// brokenListener fails every Accept, recording whether it was closed.
+type brokenListener struct {
+	net.Listener
+	closed chan struct{}
+}
+
+func (l *brokenListener) Accept() (net.Conn, error) {
+	return nil, errors.New("broken")
+}
+
+func (l *brokenListener) Close() error {
+	select {
+	case <-l.closed:
+	default:
+		close(l.closed)
+	}
+	return l.Listener.Close()
+}
+
+func TestServer_ServeAllError(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken := &brokenListener{Listener: inner, closed: make(chan struct{})}
+	s := telnet.NewServer("", telnet.HandleFunc(func(c *telnet.Connection) {}))
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ServeAll(tcp, broken)
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil || err.Error() != "broken" {
+			t.Errorf("Expected error from broken listener, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected ServeAll to return after an Accept error")
+	}
+	select {
+	case <-broken.closed:
+	default:
+		t.Error("Expected failed listener to be closed")
+	}
+	if c, err := net.Dial("tcp", tcp.Addr().String()); err == nil {
+		c.Close()
+		t.Error("Expected other listener to be closed")
+	}
+}
+
+func TestListenUnix_Stale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "telnet.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Leave the socket file behind, as a crashed process would.
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	l, err = telnet.ListenUnix(path)
+	if err != nil {
+		t.Fatalf("Expected stale socket to be replaced, got %v", err)
+	}
+	defer l.Close()
+	if _, err := telnet.ListenUnix(path); err == nil {
+		t.Error("Expected error listening on a live socket")
+	}
+}
+
+func TestSystemdListeners(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	listeners, err := telnet.SystemdListeners()
+	if listeners != nil || err != nil {
+		t.Errorf("Expected no listeners for another process, got %v, %v", listeners, err)
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Error("Expected LISTEN_FDS to be cleared")
+	}
+}
@@ -0,0 +1,125 @@
+package telnet
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrLoggedOut is the cause of a connection's Context being cancelled when
+// logout is agreed; see context.Cause.
+var ErrLoggedOut = errors.New("telnet: logged out")
+
+// LOGOUTOption enables LOGOUT (RFC 727) negotiation on a Server. A client's
+// DO LOGOUT, asking to be logged out, is accepted with WILL LOGOUT, and
+// RequestLogout politely tells the client the server wants to log it out by
+// sending WILL LOGOUT, which the client agrees to with DO LOGOUT.
+//
+// Once logout is agreed, LoggedOut is closed, the connection's Context is
+// cancelled with cause ErrLoggedOut, and Read returns io.EOF after any data
+// already received has been consumed. This lets the handler finish its
+// cleanup and return, after which the Server closes the socket.
+func LOGOUTOption(c *Connection) Negotiator {
+	return &LOGOUTHandler{conn: c, loggedOut: make(chan struct{})}
+}
+
+// ExposeLOGOUT enables LOGOUT negotiation on a Client. RequestLogout asks the
+// server to log the client out by sending DO LOGOUT, and a server's WILL
+// LOGOUT, announcing that it is logging the client out, is agreed to with DO
+// LOGOUT. Either way, once logout is agreed the connection ends as described
+// for LOGOUTOption.
+func ExposeLOGOUT(c *Connection) Negotiator {
+	return &LOGOUTHandler{conn: c, client: true, loggedOut: make(chan struct{})}
+}
+
+// LOGOUTHandler negotiates LOGOUT for a specific connection.
+type LOGOUTHandler struct {
+	conn   *Connection
+	client bool
+
+	mu        sync.Mutex
+	requested bool
+	loggedOut chan struct{}
+	once      sync.Once
+}
+
+func (l *LOGOUTHandler) OptionCode() byte {
+	return LOGOUT
+}
+
+func (l *LOGOUTHandler) Offer(c *Connection) {}
+
+// HandleDo handles the client asking to be logged out, or agreeing to a
+// logout requested by the server.
+func (l *LOGOUTHandler) HandleDo(c *Connection) {
+	if l.client {
+		c.WriteCommand(WONT, l.OptionCode())
+		return
+	}
+	if !l.clearRequested() {
+		c.WriteCommand(WILL, l.OptionCode())
+	}
+	l.logout(c)
+}
+
+// HandleWill handles the server agreeing to a logout requested by the client,
+// or announcing that it is logging the client out.
+func (l *LOGOUTHandler) HandleWill(c *Connection) {
+	if !l.client {
+		c.WriteCommand(DONT, l.OptionCode())
+		return
+	}
+	if !l.clearRequested() {
+		c.WriteCommand(DO, l.OptionCode())
+	}
+	l.logout(c)
+}
+
+func (l *LOGOUTHandler) HandleSB(c *Connection, b []byte) {}
+
+// HandleWont handles the server refusing a logout requested by the client.
+func (l *LOGOUTHandler) HandleWont(c *Connection) {
+	l.clearRequested()
+}
+
+// HandleDont handles the client refusing a logout requested by the server.
+func (l *LOGOUTHandler) HandleDont(c *Connection) {
+	l.clearRequested()
+}
+
+// RequestLogout politely asks to end the session: on a Server by sending WILL
+// LOGOUT, and on a Client by sending DO LOGOUT. If the remote end agrees,
+// LoggedOut is closed; if it refuses, nothing changes, and RequestLogout may
+// be called again.
+func (l *LOGOUTHandler) RequestLogout() error {
+	l.mu.Lock()
+	l.requested = true
+	l.mu.Unlock()
+	if l.client {
+		return l.conn.WriteCommand(DO, l.OptionCode())
+	}
+	return l.conn.WriteCommand(WILL, l.OptionCode())
+}
+
+// LoggedOut returns a channel which is closed once logout has been agreed by
+// both ends.
+func (l *LOGOUTHandler) LoggedOut() <-chan struct{} {
+	return l.loggedOut
+}
+
+// clearRequested clears any outstanding request, reporting whether there was
+// one, in which case the remote end's reply needs no answer.
+func (l *LOGOUTHandler) clearRequested() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	requested := l.requested
+	l.requested = false
+	return requested
+}
+
+func (l *LOGOUTHandler) logout(c *Connection) {
+	l.once.Do(func() {
+		c.eof = true
+		close(l.loggedOut)
+		c.cancel(ErrLoggedOut)
+	})
+}
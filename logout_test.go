@@ -0,0 +1,182 @@
+package telnet_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aprice/telnet"
+)
+
+func TestLOGOUTRemoteRequest(t *testing.T) {
+	// "bye" IAC DO LOGOUT
+	sc := newScriptConn([]byte("bye"), []byte{255, 253, 18})
+	conn := telnet.NewConnection(sc, []telnet.Option{telnet.LOGOUTOption})
+	lo := conn.OptionHandlers[telnet.LOGOUT].(*telnet.LOGOUTHandler)
+	b := make([]byte, 32)
+	n, err := conn.Read(b)
+	if err != nil {
+		t.Error(err)
+	}
+	if string(b[:n]) != "bye" {
+		t.Errorf("Expected %q, got %q", "bye", b[:n])
+	}
+	select {
+	case <-lo.LoggedOut():
+	default:
+		t.Error("Expected LoggedOut to be closed")
+	}
+	n, err = conn.Read(b)
+	if n != 0 || err != io.EOF {
+		t.Errorf("Expected 0, EOF after logout, got %d, %v", n, err)
+	}
+	if !bytes.Equal([]byte{255, 251, 18}, sc.out.Bytes()) {
+		t.Errorf("Expected IAC WILL LOGOUT, received %v", sc.out.Bytes())
+	}
+}
+
+func TestLOGOUTRequestLogout(t *testing.T) {
+	tests := []struct {
+		name      string
+		option    telnet.Option
+		request   bool
+		input     []byte
+		loggedOut bool
+		sent      []byte
+	}{
+		{
+			name:      "server agreed",
+			option:    telnet.LOGOUTOption,
+			request:   true,
+			input:     []byte{255, 253, 18},
+			loggedOut: true,
+			sent:      []byte{255, 251, 18},
+		},
+		{
+			name:    "server refused",
+			option:  telnet.LOGOUTOption,
+			request: true,
+			input:   []byte{255, 254, 18, 'x'},
+			sent:    []byte{255, 251, 18},
+		},
+		{
+			// After a refusal, a DO is the client asking to log out.
+			name:      "server refused then asked",
+			option:    telnet.LOGOUTOption,
+			request:   true,
+			input:     []byte{255, 254, 18, 255, 253, 18},
+			loggedOut: true,
+			sent:      []byte{255, 251, 18, 255, 251, 18},
+		},
+		{
+			name:   "server refuses WILL",
+			option: telnet.LOGOUTOption,
+			input:  []byte{255, 251, 18, 'x'},
+			sent:   []byte{255, 254, 18},
+		},
+		{
+			name:      "client agreed",
+			option:    telnet.ExposeLOGOUT,
+			request:   true,
+			input:     []byte{255, 251, 18},
+			loggedOut: true,
+			sent:      []byte{255, 253, 18},
+		},
+		{
+			name:    "client refused",
+			option:  telnet.ExposeLOGOUT,
+			request: true,
+			input:   []byte{255, 252, 18, 'x'},
+			sent:    []byte{255, 253, 18},
+		},
+		{
+			// After a refusal, a WILL is the server logging the client out.
+			name:      "client refused then told",
+			option:    telnet.ExposeLOGOUT,
+			request:   true,
+			input:     []byte{255, 252, 18, 255, 251, 18},
+			loggedOut: true,
+			sent:      []byte{255, 253, 18, 255, 253, 18},
+		},
+		{
+			name:      "client told",
+			option:    telnet.ExposeLOGOUT,
+			input:     []byte{255, 251, 18},
+			loggedOut: true,
+			sent:      []byte{255, 253, 18},
+		},
+		{
+			name:   "client refuses DO",
+			option: telnet.ExposeLOGOUT,
+			input:  []byte{255, 253, 18, 'x'},
+			sent:   []byte{255, 252, 18},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sc := newScriptConn(test.input)
+			conn := telnet.NewConnection(sc, []telnet.Option{test.option})
+			lo := conn.OptionHandlers[telnet.LOGOUT].(*telnet.LOGOUTHandler)
+			if test.request {
+				if err := lo.RequestLogout(); err != nil {
+					t.Error(err)
+				}
+			}
+			conn.Read(make([]byte, 32))
+			var loggedOut bool
+			select {
+			case <-lo.LoggedOut():
+				loggedOut = true
+			default:
+			}
+			if loggedOut != test.loggedOut {
+				t.Errorf("Expected logged out %v, got %v", test.loggedOut, loggedOut)
+			}
+			if !bytes.Equal(test.sent, sc.out.Bytes()) {
+				t.Errorf("Expected %v sent, got %v", test.sent, sc.out.Bytes())
+			}
+		})
+	}
+}
+
+func TestLOGOUTCancelsContext(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := telnet.NewConnection(server, []telnet.Option{telnet.LOGOUTOption})
+	defer conn.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		<-conn.Context().Done()
+		done <- context.Cause(conn.Context())
+	}()
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := io.ReadAll(conn)
+		readErr <- err
+	}()
+	go io.Copy(io.Discard, client)
+	if _, err := client.Write([]byte{255, 253, 18}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-done:
+		if err != telnet.ErrLoggedOut {
+			t.Errorf("Expected cause %v, got %v", telnet.ErrLoggedOut, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Context not cancelled after logout")
+	}
+	select {
+	case err := <-readErr:
+		if err != nil {
+			t.Errorf("Expected clean EOF from Read, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read still blocked after logout")
+	}
+}
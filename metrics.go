@@ -0,0 +1,155 @@
+package telnet
+
+import (
+	"expvar"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics receives counts of a Server's or Connection's activity, for export
+// to a monitoring system. Methods are called synchronously from connection
+// goroutines, so must be safe for concurrent use and should not block.
+type Metrics interface {
+	// ConnectionAccepted is called for each connection a Server handles.
+	ConnectionAccepted()
+	// ConnectionRejected is called for each connection a Server refuses,
+	// with the reason: "limit" for connections over the Server's limits, or
+	// "proxy" for connections without a valid PROXY header.
+	ConnectionRejected(reason string)
+	// BytesRead and BytesWritten are called with the number of bytes read
+	// from and written to the underlying connection, including control
+	// sequences.
+	BytesRead(n int)
+	BytesWritten(n int)
+	// OptionNegotiated is called for each negotiation received, with the
+	// command (WILL, WONT, DO or DONT) and option code.
+	OptionNegotiated(cmd, option byte)
+	// SessionEnded is called when a connection handled by a Server is
+	// closed, with how long it was connected.
+	SessionEnded(d time.Duration)
+}
+
+// MetricsOption has the Connection report its bytes read and written and
+// options negotiated to m. Servers with Metrics set do this for every
+// connection; MetricsOption is for use with Dial or NewConnection. It
+// negotiates no option itself.
+func MetricsOption(m Metrics) Option {
+	return func(c *Connection) Negotiator {
+		c.metrics = m
+		return nil
+	}
+}
+
+// DefaultDurationBuckets are the upper bounds, in seconds, of the session
+// duration histogram buckets of an ExpvarMetrics.
+var DefaultDurationBuckets = []float64{1, 10, 60, 300, 1800, 3600, 14400}
+
+// ExpvarMetrics is a Metrics which exports its counts with the expvar
+// package, so they are served as JSON at /debug/vars by the default HTTP mux
+// alongside the process's other variables. Counters are named in the
+// Prometheus style:
+//
+//	connections_accepted_total
+//	connections_rejected_total         map of reason to count
+//	bytes_read_total
+//	bytes_written_total
+//	negotiations_total                 map of e.g. "WILL NAWS" to count
+//	session_duration_seconds           histogram of buckets, sum and count
+type ExpvarMetrics struct {
+	// Map holds all of the variables.
+	Map *expvar.Map
+
+	accepted, bytesRead, bytesWritten expvar.Int
+	rejected, negotiations            expvar.Map
+	durations                         histogram
+}
+
+// NewExpvarMetrics creates an ExpvarMetrics, publishing its variables under
+// name. Like expvar.Publish, it panics if name is already in use. If name is
+// empty, nothing is published, and the variables may be published with Map.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	m := &ExpvarMetrics{Map: new(expvar.Map)}
+	m.durations.bounds = DefaultDurationBuckets
+	m.durations.counts = make([]uint64, len(DefaultDurationBuckets)+1)
+	m.Map.Set("connections_accepted_total", &m.accepted)
+	m.Map.Set("connections_rejected_total", &m.rejected)
+	m.Map.Set("bytes_read_total", &m.bytesRead)
+	m.Map.Set("bytes_written_total", &m.bytesWritten)
+	m.Map.Set("negotiations_total", &m.negotiations)
+	m.Map.Set("session_duration_seconds", &m.durations)
+	if name != "" {
+		expvar.Publish(name, m.Map)
+	}
+	return m
+}
+
+func (m *ExpvarMetrics) ConnectionAccepted() {
+	m.accepted.Add(1)
+}
+
+func (m *ExpvarMetrics) ConnectionRejected(reason string) {
+	m.rejected.Add(reason, 1)
+}
+
+func (m *ExpvarMetrics) BytesRead(n int) {
+	m.bytesRead.Add(int64(n))
+}
+
+func (m *ExpvarMetrics) BytesWritten(n int) {
+	m.bytesWritten.Add(int64(n))
+}
+
+func (m *ExpvarMetrics) OptionNegotiated(cmd, option byte) {
+	m.negotiations.Add(Event{Command: cmd, Option: option}.String(), 1)
+}
+
+func (m *ExpvarMetrics) SessionEnded(d time.Duration) {
+	m.durations.observe(d.Seconds())
+}
+
+// histogram is a Prometheus-style histogram exported as an expvar.Var, with
+// cumulative bucket counts keyed by upper bound.
+type histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []uint64 // per bucket, not cumulative; the last is +Inf
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	i := 0
+	for i < len(h.bounds) && v > h.bounds[i] {
+		i++
+	}
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+// String implements expvar.Var, returning the histogram as JSON.
+func (h *histogram) String() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var sb strings.Builder
+	sb.WriteString(`{"buckets": {`)
+	var cumulative uint64
+	for i, n := range h.counts {
+		cumulative += n
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = strconv.FormatFloat(h.bounds[i], 'g', -1, 64)
+		}
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		fmt.Fprintf(&sb, "%q: %d", le, cumulative)
+	}
+	fmt.Fprintf(&sb, `}, "sum": %s, "count": %d}`, strconv.FormatFloat(h.sum, 'g', -1, 64), h.count)
+	return sb.String()
+}
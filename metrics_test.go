@@ -0,0 +1,83 @@
+package telnet_test
+
+import (
+	"encoding/json"
+	"expvar"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aprice/telnet"
+)
+
+func TestServer_Metrics(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Names may only be published once per process, even with -count.
+	m := telnet.NewExpvarMetrics("")
+	if expvar.Get("telnet_test") == nil {
+		if published := telnet.NewExpvarMetrics("telnet_test"); expvar.Get("telnet_test") != published.Map {
+			t.Error("Expected metrics to be published")
+		}
+	}
+	started := make(chan struct{})
+	disconnected := make(chan struct{}, 1)
+	s := telnet.NewServer("", telnet.HandleFunc(func(c *telnet.Connection) {
+		close(started)
+		b := make([]byte, 5)
+		io.ReadFull(c, b)
+		c.Write([]byte("bye"))
+	}), telnet.NAWSOption)
+	s.MaxConnections = 1
+	s.Metrics = m
+	s.OnDisconnect = func(c *telnet.Connection, d time.Duration) {
+		disconnected <- struct{}{}
+	}
+	go s.Serve(l)
+	defer s.Close()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Read(make([]byte, 3)) // IAC DO NAWS
+	<-started
+	dialAndRead(t, l.Addr().String())
+	client.Write([]byte{255, 251, 31, 255, 250, 31, 0, 80, 0, 24, 255, 240})
+	client.Write([]byte("hello"))
+	<-disconnected
+
+	var vars struct {
+		Accepted     int            `json:"connections_accepted_total"`
+		Rejected     map[string]int `json:"connections_rejected_total"`
+		BytesRead    int            `json:"bytes_read_total"`
+		BytesWritten int            `json:"bytes_written_total"`
+		Negotiations map[string]int `json:"negotiations_total"`
+		Durations    struct {
+			Buckets map[string]int `json:"buckets"`
+			Count   int            `json:"count"`
+		} `json:"session_duration_seconds"`
+	}
+	if err := json.Unmarshal([]byte(m.Map.String()), &vars); err != nil {
+		t.Fatalf("Expected valid JSON, got %v: %s", err, m.Map.String())
+	}
+	if vars.Accepted != 1 || vars.Rejected["limit"] != 1 {
+		t.Errorf("Expected 1 accepted and 1 rejected, got %d, %v", vars.Accepted, vars.Rejected)
+	}
+	if vars.BytesRead != 17 {
+		t.Errorf("Expected 17 bytes read, got %d", vars.BytesRead)
+	}
+	if vars.BytesWritten != 6 {
+		t.Errorf("Expected 6 bytes written, got %d", vars.BytesWritten)
+	}
+	if vars.Negotiations["WILL NAWS"] != 1 {
+		t.Errorf("Expected WILL NAWS to be counted, got %v", vars.Negotiations)
+	}
+	if vars.Durations.Count != 1 || vars.Durations.Buckets["1"] != 1 || vars.Durations.Buckets["+Inf"] != 1 {
+		t.Errorf("Expected one short session, got %+v", vars.Durations)
+	}
+}
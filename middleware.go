@@ -0,0 +1,122 @@
+package telnet
+
+import (
+	"log"
+	"net/netip"
+	"time"
+)
+
+// Middleware wraps a Handler to add behavior before, after or around it, in
+// the manner of net/http middleware.
+type Middleware func(Handler) Handler
+
+// Chain wraps h in the given middleware. The first middleware is outermost,
+// so it sees each connection first.
+func Chain(h Handler, middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// Use installs middleware around the Server's handler, as with Chain.
+// Middleware installed by earlier calls to Use is outermost. Use must be
+// called before Serve.
+func (s *Server) Use(middleware ...Middleware) {
+	s.middleware = append(s.middleware, middleware...)
+}
+
+// AccessLog logs each connection, when it is opened and when its handler
+// returns, to l. If l is nil, the log package's standard logger is used.
+func AccessLog(l *log.Logger) Middleware {
+	logf := log.Printf
+	if l != nil {
+		logf = l.Printf
+	}
+	return func(next Handler) Handler {
+		return HandleFunc(func(c *Connection) {
+			start := time.Now()
+			logf("telnet: connection from %v", c.RemoteAddr())
+			defer func() {
+				logf("telnet: connection from %v closed after %v", c.RemoteAddr(), time.Since(start))
+			}()
+			next.HandleTelnet(c)
+		})
+	}
+}
+
+// IdleTimeout closes connections which have not received any data from the
+// client for d, interrupting any blocked Read. Output sent to the client, and
+// control sequences received from it, do not count as activity. Server has
+// its own IdleTimeout, which can also warn clients before disconnecting them.
+func IdleTimeout(d time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return HandleFunc(func(c *Connection) {
+			done := make(chan struct{})
+			defer close(done)
+			go c.watchIdle(done, d, 0, "")
+			next.HandleTelnet(c)
+		})
+	}
+}
+
+// AllowPrefixes only handles connections from IP addresses within one of the
+// given prefixes; others are closed without calling the handler. Connections
+// without an IP address, such as streams, are refused.
+func AllowPrefixes(prefixes ...netip.Prefix) Middleware {
+	return func(next Handler) Handler {
+		return HandleFunc(func(c *Connection) {
+			if ip, ok := remoteIP(c); ok && containsIP(prefixes, ip) {
+				next.HandleTelnet(c)
+			}
+		})
+	}
+}
+
+// DenyPrefixes closes connections from IP addresses within any of the given
+// prefixes without calling the handler. Connections without an IP address,
+// such as streams, are handled.
+func DenyPrefixes(prefixes ...netip.Prefix) Middleware {
+	return func(next Handler) Handler {
+		return HandleFunc(func(c *Connection) {
+			if ip, ok := remoteIP(c); !ok || !containsIP(prefixes, ip) {
+				next.HandleTelnet(c)
+			}
+		})
+	}
+}
+
+// Banner writes text to each connection before calling the handler. Telnet
+// expects CRLF line endings, which text should use.
+func Banner(text string) Middleware {
+	return func(next Handler) Handler {
+		return HandleFunc(func(c *Connection) {
+			if _, err := c.Write([]byte(text)); err != nil {
+				return
+			}
+			next.HandleTelnet(c)
+		})
+	}
+}
+
+// remoteIP returns the IP address of the remote end of c, if it has one.
+func remoteIP(c *Connection) (netip.Addr, bool) {
+	addr := c.RemoteAddr()
+	if addr == nil {
+		return netip.Addr{}, false
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ap.Addr().Unmap(), true
+}
+
+func containsIP(prefixes []netip.Prefix, ip netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package telnet_test
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aprice/telnet"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	mw := func(name string) telnet.Middleware {
+		return func(next telnet.Handler) telnet.Handler {
+			return telnet.HandleFunc(func(c *telnet.Connection) {
+				order = append(order, name)
+				next.HandleTelnet(c)
+			})
+		}
+	}
+	h := telnet.Chain(telnet.HandleFunc(func(c *telnet.Connection) {
+		order = append(order, "handler")
+	}), mw("a"), mw("b"))
+	h.HandleTelnet(nil)
+	if strings.Join(order, ",") != "a,b,handler" {
+		t.Errorf("Expected a,b,handler, got %v", order)
+	}
+}
+
+func TestServer_Use(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logBuf bytes.Buffer
+	s := telnet.NewServer("", telnet.HandleFunc(func(c *telnet.Connection) {
+		c.Write([]byte("Hello!"))
+	}))
+	s.Use(telnet.AccessLog(log.New(&logBuf, "", 0)), telnet.Banner("Welcome\r\n"))
+	disconnected := make(chan struct{}, 1)
+	s.OnDisconnect = func(c *telnet.Connection, d time.Duration) {
+		disconnected <- struct{}{}
+	}
+	go s.Serve(l)
+	defer s.Close()
+
+	if got := dialAndRead(t, l.Addr().String()); got != "Welcome\r\nHello!" {
+		t.Errorf("Expected banner and greeting, got %q", got)
+	}
+	<-disconnected
+	if strings.Count(logBuf.String(), "connection from 127.0.0.1") != 2 {
+		t.Errorf("Expected connection and disconnection to be logged, got %q", logBuf.String())
+	}
+}
+
+func TestAllowDenyPrefixes(t *testing.T) {
+	loopback := netip.MustParsePrefix("127.0.0.0/8")
+	other := netip.MustParsePrefix("192.0.2.0/24")
+	tests := []struct {
+		name     string
+		mw       telnet.Middleware
+		expected string
+	}{
+		{"allow match", telnet.AllowPrefixes(loopback), "Hello!"},
+		{"allow no match", telnet.AllowPrefixes(other), ""},
+		{"deny match", telnet.DenyPrefixes(other, loopback), ""},
+		{"deny no match", telnet.DenyPrefixes(other), "Hello!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := telnet.NewServer("", telnet.HandleFunc(func(c *telnet.Connection) {
+				c.Write([]byte("Hello!"))
+			}))
+			s.Use(tt.mw)
+			go s.Serve(l)
+			defer s.Close()
+			if got := dialAndRead(t, l.Addr().String()); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := telnet.NewConnection(server, nil)
+	readErr := make(chan error, 1)
+	h := telnet.Chain(telnet.HandleFunc(func(c *telnet.Connection) {
+		b := make([]byte, 8)
+		// Activity before the timeout keeps the connection open.
+		if _, err := c.Read(b); err != nil {
+			readErr <- err
+			return
+		}
+		_, err := c.Read(b)
+		readErr <- err
+	}), telnet.IdleTimeout(50*time.Millisecond))
+	go h.HandleTelnet(conn)
+
+	time.Sleep(30 * time.Millisecond)
+	client.Write([]byte("x"))
+	time.Sleep(30 * time.Millisecond)
+	select {
+	case err := <-readErr:
+		t.Fatalf("Expected connection to still be open, got %v", err)
+	default:
+	}
+	select {
+	case err := <-readErr:
+		if err == nil {
+			t.Error("Expected read to fail once idle")
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected idle connection to be closed")
+	}
+}
@@ -0,0 +1,184 @@
+package telnet_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aprice/telnet"
+)
+
+func TestServer_NegotiationTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	type result struct {
+		width, height uint16
+		data          string
+	}
+	results := make(chan result, 1)
+	s := telnet.NewServer("", telnet.HandleFunc(func(c *telnet.Connection) {
+		naws := c.OptionHandlers[telnet.NAWS].(*telnet.NAWSHandler)
+		r := result{width: naws.Width, height: naws.Height}
+		b := make([]byte, 5)
+		io.ReadFull(c, b)
+		r.data = string(b)
+		results <- r
+	}), telnet.NAWSOption, telnet.TSPEEDOption)
+	s.NegotiationTimeout = time.Second
+	go s.Serve(l)
+	defer s.Close()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	b := make([]byte, 6)
+	if _, err := io.ReadFull(client, b); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, []byte{255, 253, 31, 255, 253, 32}) {
+		t.Fatalf("Expected IAC DO NAWS IAC DO TSPEED, got %v", b)
+	}
+	// Answer slowly, and in pieces, with data mixed in.
+	for _, chunk := range [][]byte{
+		{255, 252, 32},                         // IAC WONT TSPEED
+		{255, 251, 31},                         // IAC WILL NAWS
+		[]byte("hel"),                          // data before negotiation settles
+		{255, 250, 31, 0, 80, 0, 24, 255, 240}, // IAC SB NAWS 80 24 IAC SE
+		[]byte("lo"),
+	} {
+		time.Sleep(10 * time.Millisecond)
+		select {
+		case <-results:
+			t.Fatal("Expected handler to wait for negotiation")
+		default:
+		}
+		client.Write(chunk)
+	}
+	select {
+	case r := <-results:
+		if r.width != 80 || r.height != 24 {
+			t.Errorf("Expected handler to see 80x24, got %dx%d", r.width, r.height)
+		}
+		if r.data != "hello" {
+			t.Errorf("Expected data received during negotiation to be kept, got %q", r.data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected handler to be called")
+	}
+}
+
+func TestConnection_AwaitNegotiation(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go io.Copy(io.Discard, client)
+	conn := telnet.NewConnection(server, []telnet.Option{telnet.NAWSOption})
+	defer conn.Close()
+	// The client never answers.
+	start := time.Now()
+	if conn.AwaitNegotiation(30 * time.Millisecond) {
+		t.Error("Expected negotiation not to settle")
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Expected to wait for the timeout, waited %v", elapsed)
+	}
+
+	// Replies to the peer's own offers are not awaited.
+	client2, server2 := net.Pipe()
+	defer client2.Close()
+	go func() {
+		client2.Write([]byte{255, 253, 33}) // IAC DO LFLOW
+		client2.Write([]byte{255, 251, 31}) // IAC WILL NAWS
+		client2.Write([]byte{255, 250, 31, 0, 80, 0, 24, 255, 240})
+	}()
+	go io.Copy(io.Discard, client2)
+	conn2 := telnet.NewConnection(server2, []telnet.Option{telnet.ExposeLFLOW, telnet.NAWSOption})
+	defer conn2.Close()
+	if !conn2.AwaitNegotiation(time.Second) {
+		t.Error("Expected negotiation to settle")
+	}
+}
+
+func TestConnection_AwaitNegotiationRecords(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		client.Write([]byte{'a', 'b', 255, 239, 'c', 'd'}) // IAC EOR
+		client.Write([]byte{255, 251, 31})                 // IAC WILL NAWS
+		client.Write([]byte{'e', 255, 239, 255, 239})      // an empty record
+		client.Write([]byte{255, 250, 31, 0, 80, 0, 24, 255, 240})
+		client.Write([]byte{'f', 255, 239})
+	}()
+	go io.Copy(io.Discard, client)
+	conn := telnet.NewConnection(server, []telnet.Option{telnet.NAWSOption})
+	defer conn.Close()
+	if !conn.AwaitNegotiation(time.Second) {
+		t.Fatal("Expected negotiation to settle")
+	}
+	for _, expected := range []string{"ab", "cde", "", "f"} {
+		rec, err := conn.ReadRecord()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(rec) != expected {
+			t.Errorf("Expected record %q, got %q", expected, rec)
+		}
+	}
+}
+
+func TestConnection_AwaitNegotiationDeadline(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go io.Copy(io.Discard, client)
+	conn := telnet.NewConnection(server, []telnet.Option{telnet.NAWSOption})
+	defer conn.Close()
+	deadline := time.Now().Add(50 * time.Millisecond)
+	conn.SetReadDeadline(deadline)
+	// The earlier deadline cuts negotiation short, and is kept afterwards.
+	if conn.AwaitNegotiation(time.Second) {
+		t.Error("Expected negotiation not to settle")
+	}
+	if time.Now().After(deadline.Add(500 * time.Millisecond)) {
+		t.Error("Expected negotiation to stop at the read deadline")
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 8))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("Expected read deadline to be restored, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected read deadline to be restored")
+	}
+}
+
+func TestConnection_AwaitNegotiationNoDeadline(t *testing.T) {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	defer inW.Close()
+	go io.Copy(io.Discard, outR)
+	conn := telnet.NewStreamConnection(pipeStream{inR, outW}, []telnet.Option{telnet.NAWSOption})
+	defer conn.Close()
+	done := make(chan bool, 1)
+	go func() { done <- conn.AwaitNegotiation(50 * time.Millisecond) }()
+	select {
+	case settled := <-done:
+		if settled {
+			t.Error("Expected negotiation not to settle")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected AwaitNegotiation not to block without deadline support")
+	}
+}
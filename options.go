@@ -9,11 +9,24 @@ import (
 )
 
 const (
-	ECHO    = byte(1)
-	TTYPE   = byte(24)
-	NAWS    = byte(31)
-	ENCRYPT = byte(38)
-	EOR     = byte(239)
+	ECHO     = byte(1)
+	TM       = byte(6)
+	LOGOUT   = byte(18)
+	SNDLOC   = byte(23)
+	TTYPE    = byte(24)
+	NAWS     = byte(31)
+	TSPEED   = byte(32)
+	LFLOW    = byte(33)
+	XDISPLOC = byte(35)
+	ENCRYPT  = byte(38)
+	TN3270E  = byte(40)
+)
+
+// Subnegotiation qualifiers shared by options that exchange values, such as
+// TTYPE, TSPEED and XDISPLOC.
+const (
+	IS   = byte(0)
+	SEND = byte(1)
 )
 
 // NAWSOption enables NAWS negotiation on a Server.
@@ -31,6 +44,10 @@ func ExposeNAWS(c *Connection) Negotiator {
 type NAWSHandler struct {
 	Width  uint16
 	Height uint16
+	// OnResize, if set, is called on a Server with the new size each time the
+	// client reports its window size. It must be set before the connection is
+	// read.
+	OnResize func(width, height uint16)
 
 	client bool
 }
@@ -41,24 +58,29 @@ func (n *NAWSHandler) OptionCode() byte {
 
 func (n *NAWSHandler) Offer(c *Connection) {
 	if !n.client {
-		c.Conn.Write([]byte{IAC, DO, n.OptionCode()})
+		c.WriteCommand(DO, n.OptionCode())
 	}
 }
 
-func (n *NAWSHandler) HandleWill(c *Connection) {}
+func (n *NAWSHandler) HandleWill(c *Connection) {
+	if !n.client {
+		c.AwaitSubnegotiation(n.OptionCode())
+	}
+}
 
 func (n *NAWSHandler) HandleDo(c *Connection) {
 	if n.client {
-		c.Conn.Write([]byte{IAC, WILL, n.OptionCode()})
+		c.WriteCommand(WILL, n.OptionCode())
 		n.writeSize(c)
 		go n.monitorTTYSize(c)
 	} else {
-		c.Conn.Write([]byte{IAC, WONT, n.OptionCode()})
+		c.WriteCommand(WONT, n.OptionCode())
 	}
 }
 
 func (n *NAWSHandler) monitorTTYSize(c *Connection) {
 	t := time.NewTicker(time.Second)
+	defer t.Stop()
 	for range t.C {
 		w, h, err := terminal.GetSize(int(os.Stdin.Fd()))
 		if err != nil {
@@ -69,24 +91,27 @@ func (n *NAWSHandler) monitorTTYSize(c *Connection) {
 		if width != n.Width || height != n.Height {
 			n.Width = width
 			n.Height = height
-			n.writeSize(c)
+			if err := n.writeSize(c); err != nil {
+				// The connection is gone; stop monitoring.
+				return
+			}
 		}
 	}
 }
 
-func (n *NAWSHandler) writeSize(c *Connection) {
-	c.Conn.Write([]byte{IAC, SB, n.OptionCode()})
+func (n *NAWSHandler) writeSize(c *Connection) error {
 	payload := make([]byte, 4)
 	binary.BigEndian.PutUint16(payload, n.Width)
 	binary.BigEndian.PutUint16(payload[2:], n.Height)
-	// Normal write - we want inadvertent IACs to be escaped in body
-	c.Write(payload)
-	c.Conn.Write([]byte{IAC, SE})
+	return c.WriteSubnegotiation(n.OptionCode(), payload)
 }
 
 func (n *NAWSHandler) HandleSB(c *Connection, b []byte) {
-	if !n.client {
+	if !n.client && len(b) >= 4 {
 		n.Width = binary.BigEndian.Uint16(b[0:2])
 		n.Height = binary.BigEndian.Uint16(b[2:4])
+		if n.OnResize != nil {
+			n.OnResize(n.Width, n.Height)
+		}
 	}
 }
@@ -0,0 +1,160 @@
+package telnet
+
+// DefaultMaxSubnegotiation is the subnegotiation body limit used by a Parser
+// whose MaxSubnegotiation is zero.
+const DefaultMaxSubnegotiation = 4096
+
+// Event is a control sequence decoded from a telnet stream by a Parser.
+type Event struct {
+	// Command is the command following IAC: WILL, WONT, DO or DONT for
+	// negotiations, SB for subnegotiations, or a command which takes no
+	// option, such as NOP, GA or EOR.
+	Command byte
+	// Option is the option code of a negotiation or subnegotiation.
+	Option byte
+	// Body holds the bytes between `IAC SB <Option>` and `IAC SE`, with
+	// escaped IACs already removed. It is only valid until the next call to
+	// Parse.
+	Body []byte
+	// Truncated is set if the subnegotiation body was longer than the
+	// parser's limit; Body holds only as much as fit.
+	Truncated bool
+}
+
+// Parser is an incremental parser for telnet streams, which separates data
+// from control sequences. It keeps its state between calls to Parse, so the
+// stream may be fed to it in chunks split at any point, including in the
+// middle of a control sequence. It does not depend on a Connection, so may be
+// used to parse telnet streams from any source.
+//
+// The zero value is ready to use.
+type Parser struct {
+	// MaxSubnegotiation is the longest subnegotiation body the parser will
+	// buffer. If zero, DefaultMaxSubnegotiation is used.
+	MaxSubnegotiation int
+
+	state     parseState
+	ev        Event
+	truncated bool
+}
+
+// parseState is the position of a Parser within the telnet stream.
+type parseState int
+
+const (
+	stateData     parseState = iota // plain data
+	stateIAC                        // after IAC
+	stateOption                     // after IAC WILL/WONT/DO/DONT
+	stateSBOption                   // after IAC SB
+	stateSB                         // in subnegotiation body
+	stateSBIAC                      // after IAC in subnegotiation body
+)
+
+// Parse decodes src, copying data into dst with control sequences removed,
+// until src is exhausted, dst is full, or a control sequence is completed. It
+// returns the number of bytes written to dst and consumed from src, and the
+// completed control sequence, if any. The Event is owned by the Parser and is
+// only valid until the next call to Parse.
+//
+// Callers should keep calling Parse with the remainder of src until it has
+// all been consumed, handling each Event as it is returned.
+func (p *Parser) Parse(dst, src []byte) (nDst, nSrc int, ev *Event) {
+	for nSrc < len(src) {
+		ch := src[nSrc]
+		switch p.state {
+		case stateData:
+			if ch == IAC {
+				p.state = stateIAC
+			} else {
+				if nDst == len(dst) {
+					return
+				}
+				dst[nDst] = ch
+				nDst++
+			}
+		case stateIAC:
+			switch ch {
+			case IAC:
+				// Escaped IAC in data
+				if nDst == len(dst) {
+					return
+				}
+				dst[nDst] = IAC
+				nDst++
+				p.state = stateData
+			case WILL, WONT, DO, DONT:
+				p.ev.Command = ch
+				p.state = stateOption
+			case SB:
+				p.state = stateSBOption
+			default:
+				// Commands which take no option
+				p.state = stateData
+				return nDst, nSrc + 1, p.event(ch, 0)
+			}
+		case stateOption:
+			p.state = stateData
+			return nDst, nSrc + 1, p.event(p.ev.Command, ch)
+		case stateSBOption:
+			p.ev.Option = ch
+			p.ev.Body = p.ev.Body[:0]
+			p.truncated = false
+			p.state = stateSB
+		case stateSB:
+			if ch == IAC {
+				p.state = stateSBIAC
+			} else {
+				p.appendSB(ch)
+			}
+		case stateSBIAC:
+			switch ch {
+			case IAC:
+				// Escaped IAC in subnegotiation body
+				p.appendSB(IAC)
+				p.state = stateSB
+			case SE:
+				p.state = stateData
+				ev := p.event(SB, p.ev.Option)
+				ev.Truncated = p.truncated
+				return nDst, nSrc + 1, ev
+			default:
+				// Unterminated subnegotiation; drop it and treat this as
+				// the command following IAC, without consuming it.
+				p.state = stateIAC
+				continue
+			}
+		}
+		nSrc++
+	}
+	return
+}
+
+// Reset discards any partially parsed control sequence, returning the Parser
+// to its initial state.
+func (p *Parser) Reset() {
+	p.state = stateData
+	p.ev.Body = p.ev.Body[:0]
+	p.truncated = false
+}
+
+func (p *Parser) event(cmd, option byte) *Event {
+	p.ev.Command = cmd
+	p.ev.Option = option
+	p.ev.Truncated = false
+	if cmd != SB {
+		p.ev.Body = p.ev.Body[:0]
+	}
+	return &p.ev
+}
+
+func (p *Parser) appendSB(ch byte) {
+	max := p.MaxSubnegotiation
+	if max == 0 {
+		max = DefaultMaxSubnegotiation
+	}
+	if len(p.ev.Body) >= max {
+		p.truncated = true
+		return
+	}
+	p.ev.Body = append(p.ev.Body, ch)
+}
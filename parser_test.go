@@ -0,0 +1,100 @@
+package telnet_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/aprice/telnet"
+)
+
+// parseChunks feeds each chunk to p in turn, using a destination buffer of
+// dstLen bytes, and returns the data and events parsed.
+func parseChunks(p *telnet.Parser, dstLen int, chunks ...[]byte) ([]byte, []telnet.Event) {
+	var data []byte
+	var events []telnet.Event
+	dst := make([]byte, dstLen)
+	for _, src := range chunks {
+		for len(src) > 0 {
+			nDst, nSrc, ev := p.Parse(dst, src)
+			data = append(data, dst[:nDst]...)
+			src = src[nSrc:]
+			if ev != nil {
+				e := *ev
+				e.Body = append([]byte(nil), ev.Body...)
+				events = append(events, e)
+			}
+		}
+	}
+	return data, events
+}
+
+func TestParser_Splits(t *testing.T) {
+	stream := bytes.Join([][]byte{
+		[]byte("ab"),
+		{255, 255}, // escaped IAC
+		[]byte("c"),
+		{255, 251, 31}, // IAC WILL NAWS
+		{255, 250, 31, 0, 255, 255, 0, 80, 255, 240}, // IAC SB NAWS ... IAC SE
+		{255, 241}, // IAC NOP
+		[]byte("d"),
+		{255, 239},                  // IAC EOR
+		{255, 250, 24, 1, 255, 240}, // IAC SB TTYPE SEND IAC SE
+		[]byte("e"),
+	}, nil)
+	expectedData := []byte("ab\xffcde")
+	expectedEvents := []telnet.Event{
+		{Command: telnet.WILL, Option: telnet.NAWS},
+		{Command: telnet.SB, Option: telnet.NAWS, Body: []byte{0, 255, 0, 80}},
+		{Command: telnet.NOP},
+		{Command: telnet.EOR},
+		{Command: telnet.SB, Option: telnet.TTYPE, Body: []byte{1}},
+	}
+	check := func(t *testing.T, data []byte, events []telnet.Event) {
+		t.Helper()
+		if !bytes.Equal(expectedData, data) {
+			t.Errorf("Expected data %q, got %q", expectedData, data)
+		}
+		if !reflect.DeepEqual(expectedEvents, events) {
+			t.Errorf("Expected events %v, got %v", expectedEvents, events)
+		}
+	}
+
+	for i := 0; i <= len(stream); i++ {
+		data, events := parseChunks(new(telnet.Parser), 32, stream[:i], stream[i:])
+		check(t, data, events)
+	}
+
+	var chunks [][]byte
+	for i := range stream {
+		chunks = append(chunks, stream[i:i+1])
+	}
+	data, events := parseChunks(new(telnet.Parser), 1, chunks...)
+	check(t, data, events)
+}
+
+func TestParser_Truncated(t *testing.T) {
+	p := &telnet.Parser{MaxSubnegotiation: 2}
+	// IAC SB TTYPE IS "vt100" IAC SE "x"
+	stream := append(append([]byte{255, 250, 24, 0}, "vt100"...), 255, 240, 'x')
+	data, events := parseChunks(p, 32, stream)
+	if string(data) != "x" {
+		t.Errorf("Expected data %q, got %q", "x", data)
+	}
+	if len(events) != 1 || !events[0].Truncated || !bytes.Equal(events[0].Body, []byte{0, 'v'}) {
+		t.Errorf("Expected truncated subnegotiation, got %v", events)
+	}
+}
+
+func TestParser_Unterminated(t *testing.T) {
+	// IAC SB NAWS 0 80 IAC WILL ECHO "x" - the subnegotiation is dropped
+	stream := []byte{255, 250, 31, 0, 80, 255, 251, 1, 'x'}
+	data, events := parseChunks(new(telnet.Parser), 32, stream)
+	if string(data) != "x" {
+		t.Errorf("Expected data %q, got %q", "x", data)
+	}
+	expected := []telnet.Event{{Command: telnet.WILL, Option: telnet.ECHO}}
+	if !reflect.DeepEqual(expected, events) {
+		t.Errorf("Expected events %v, got %v", expected, events)
+	}
+}
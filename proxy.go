@@ -0,0 +1,326 @@
+package telnet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultProxyHeaderTimeout is how long a ProxyListener with no HeaderTimeout
+// waits for a connection's PROXY header.
+const DefaultProxyHeaderTimeout = 10 * time.Second
+
+// PROXY protocol v2 TLV types.
+const (
+	ProxyTLVALPN      = byte(0x01)
+	ProxyTLVAuthority = byte(0x02)
+	ProxyTLVCRC32C    = byte(0x03)
+	ProxyTLVNOOP      = byte(0x04)
+	ProxyTLVUniqueID  = byte(0x05)
+	ProxyTLVSSL       = byte(0x20)
+	ProxyTLVNetNS     = byte(0x30)
+)
+
+// proxyV2Sig is the signature which starts every PROXY protocol v2 header.
+var proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyV1Max is the longest possible PROXY protocol v1 header.
+const proxyV1Max = 107
+
+// proxyLogInterval is the least time between log lines about connections
+// refused for a bad PROXY header, so that a misconfigured client cannot flood
+// the Server's ErrorLog.
+const proxyLogInterval = 10 * time.Second
+
+// ErrProxyHeader is returned for connections which do not start with a valid
+// PROXY protocol header.
+var ErrProxyHeader = errors.New("telnet: invalid PROXY protocol header")
+
+// ProxyListener wraps a Listener whose connections come through a proxy, such
+// as HAProxy, which sends a PROXY protocol v1 or v2 header at the start of
+// each connection. The header is read from each connection by the Server,
+// before any options are offered, and RemoteAddr and LocalAddr then report the
+// addresses of the original connection. Connections without a valid header
+// are refused, so every connection must come through the proxy.
+type ProxyListener struct {
+	net.Listener
+	// HeaderTimeout bounds how long to wait for a connection's header. If
+	// zero, DefaultProxyHeaderTimeout is used.
+	HeaderTimeout time.Duration
+}
+
+// Accept waits for and returns the next connection, as a *ProxyConn. The
+// header is not read until it is needed, so that a slow client does not block
+// Accept.
+func (l *ProxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	timeout := l.HeaderTimeout
+	if timeout == 0 {
+		timeout = DefaultProxyHeaderTimeout
+	}
+	return &ProxyConn{Conn: c, r: bufio.NewReader(c), timeout: timeout}, nil
+}
+
+// ProxyTLV is a type-length-value field from a PROXY protocol v2 header.
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// ProxyConn is a connection accepted by a ProxyListener. Its header is read by
+// the first call to ReadHeader, Read, RemoteAddr or LocalAddr.
+type ProxyConn struct {
+	net.Conn
+
+	r       *bufio.Reader
+	timeout time.Duration
+
+	once     sync.Once
+	err      error
+	src, dst net.Addr
+	tlvs     []ProxyTLV
+}
+
+// ReadHeader reads the connection's PROXY header, if it hasn't been already,
+// returning any error reading or parsing it.
+func (c *ProxyConn) ReadHeader() error {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		c.err = c.readHeader()
+		c.Conn.SetReadDeadline(time.Time{})
+	})
+	return c.err
+}
+
+// Read reads data following the PROXY header.
+func (c *ProxyConn) Read(b []byte) (int, error) {
+	if err := c.ReadHeader(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns the address of the client which connected to the proxy.
+// If the header did not carry addresses, as for health checks made by the
+// proxy itself, the address of the proxy is returned.
+func (c *ProxyConn) RemoteAddr() net.Addr {
+	if c.ReadHeader() == nil && c.src != nil {
+		return c.src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address the client connected to on the proxy, or the
+// local address of the connection if the header did not carry addresses.
+func (c *ProxyConn) LocalAddr() net.Addr {
+	if c.ReadHeader() == nil && c.dst != nil {
+		return c.dst
+	}
+	return c.Conn.LocalAddr()
+}
+
+// TLVs returns the type-length-value fields of a PROXY v2 header.
+func (c *ProxyConn) TLVs() []ProxyTLV {
+	if c.ReadHeader() != nil {
+		return nil
+	}
+	return c.tlvs
+}
+
+// TLV returns the value of the first TLV of the given type, if there is one.
+func (c *ProxyConn) TLV(typ byte) ([]byte, bool) {
+	for _, tlv := range c.TLVs() {
+		if tlv.Type == typ {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// readHeader works out which version of header the connection starts with,
+// peeking no further than needed, so that neither a short v1 header nor
+// garbage leaves it waiting for bytes which will never come.
+func (c *ProxyConn) readHeader() error {
+	first, err := c.r.Peek(1)
+	if err != nil {
+		return err
+	}
+	var sig []byte
+	switch first[0] {
+	case 'P':
+		sig = []byte("PROXY ")
+	case proxyV2Sig[0]:
+		sig = proxyV2Sig
+	default:
+		return ErrProxyHeader
+	}
+	for n := 2; n <= len(sig); n++ {
+		b, err := c.r.Peek(n)
+		if err != nil {
+			return err
+		}
+		if b[n-1] != sig[n-1] {
+			return ErrProxyHeader
+		}
+	}
+	if first[0] == 'P' {
+		return c.readV1()
+	}
+	return c.readV2()
+}
+
+// readV1 reads a human-readable PROXY v1 header, e.g.
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 23\r\n".
+func (c *ProxyConn) readV1() error {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == proxyV1Max {
+			return ErrProxyHeader
+		}
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		line = append(line, b)
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 {
+		return ErrProxyHeader
+	}
+	if fields[1] == "UNKNOWN" {
+		return nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return ErrProxyHeader
+	}
+	src, err := parseV1Addr(fields[2], fields[4])
+	if err != nil {
+		return err
+	}
+	dst, err := parseV1Addr(fields[3], fields[5])
+	if err != nil {
+		return err
+	}
+	c.src, c.dst = src, dst
+	return nil
+}
+
+func parseV1Addr(host, port string) (net.Addr, error) {
+	ip := net.ParseIP(host)
+	p, err := strconv.ParseUint(port, 10, 16)
+	if ip == nil || err != nil {
+		return nil, ErrProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+// readV2 reads a binary PROXY v2 header.
+func (c *ProxyConn) readV2() error {
+	var hdr [16]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return err
+	}
+	if hdr[12]>>4 != 2 {
+		return fmt.Errorf("%w: version %d", ErrProxyHeader, hdr[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return err
+	}
+	switch hdr[12] & 0xF {
+	case 0x0:
+		// LOCAL: made by the proxy itself; addresses are ignored.
+		return nil
+	case 0x1:
+		// PROXY
+	default:
+		return fmt.Errorf("%w: command %d", ErrProxyHeader, hdr[12]&0xF)
+	}
+
+	var addrLen int
+	family, proto := hdr[13]>>4, hdr[13]&0xF
+	switch family {
+	case 0x0:
+		// AF_UNSPEC: no addresses.
+	case 0x1:
+		addrLen = 12
+	case 0x2:
+		addrLen = 36
+	case 0x3:
+		addrLen = 216
+	default:
+		return fmt.Errorf("%w: address family %d", ErrProxyHeader, family)
+	}
+	if len(body) < addrLen {
+		return ErrProxyHeader
+	}
+	switch family {
+	case 0x1, 0x2:
+		n := addrLen/2 - 2
+		srcIP, dstIP := net.IP(body[:n]), net.IP(body[n:2*n])
+		srcPort := int(binary.BigEndian.Uint16(body[2*n:]))
+		dstPort := int(binary.BigEndian.Uint16(body[2*n+2:]))
+		if proto == 0x2 {
+			c.src = &net.UDPAddr{IP: srcIP, Port: srcPort}
+			c.dst = &net.UDPAddr{IP: dstIP, Port: dstPort}
+		} else {
+			c.src = &net.TCPAddr{IP: srcIP, Port: srcPort}
+			c.dst = &net.TCPAddr{IP: dstIP, Port: dstPort}
+		}
+	case 0x3:
+		c.src = &net.UnixAddr{Name: unixPath(body[:108]), Net: "unix"}
+		c.dst = &net.UnixAddr{Name: unixPath(body[108:216]), Net: "unix"}
+	}
+
+	for tlvs := body[addrLen:]; len(tlvs) > 0; {
+		if len(tlvs) < 3 {
+			return ErrProxyHeader
+		}
+		n := int(binary.BigEndian.Uint16(tlvs[1:]))
+		if len(tlvs) < 3+n {
+			return ErrProxyHeader
+		}
+		c.tlvs = append(c.tlvs, ProxyTLV{Type: tlvs[0], Value: tlvs[3 : 3+n]})
+		tlvs = tlvs[3+n:]
+	}
+	return nil
+}
+
+// unixPath returns the NUL-terminated path in b.
+func unixPath(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// logProxyError logs a connection refused for a bad PROXY header, at most once
+// per proxyLogInterval, counting those which are not logged.
+func (s *Server) logProxyError(addr net.Addr, err error) {
+	s.mu.Lock()
+	now := time.Now()
+	if now.Sub(s.proxyLogged) < proxyLogInterval {
+		s.proxySuppressed++
+		s.mu.Unlock()
+		return
+	}
+	suppressed := s.proxySuppressed
+	s.proxyLogged, s.proxySuppressed = now, 0
+	s.mu.Unlock()
+	if suppressed > 0 {
+		s.logf("telnet: reading PROXY header from %v: %v (%d more since last logged)", addr, err, suppressed)
+	} else {
+		s.logf("telnet: reading PROXY header from %v: %v", addr, err)
+	}
+}
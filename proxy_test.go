@@ -0,0 +1,217 @@
+package telnet_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aprice/telnet"
+)
+
+// acceptProxy sends header and then data to a ProxyListener, returning the
+// accepted connection.
+func acceptProxy(t *testing.T, header, data []byte) *telnet.ProxyConn {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl := &telnet.ProxyListener{Listener: l, HeaderTimeout: time.Second}
+	t.Cleanup(func() { pl.Close() })
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	client.Write(append(append([]byte(nil), header...), data...))
+	c, err := pl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c.(*telnet.ProxyConn)
+}
+
+func TestProxyConn_V1(t *testing.T) {
+	c := acceptProxy(t, []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 23\r\n"), []byte("hi"))
+	if err := c.ReadHeader(); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.RemoteAddr().String(); got != "192.0.2.1:56324" {
+		t.Errorf("Expected remote address 192.0.2.1:56324, got %s", got)
+	}
+	if got := c.LocalAddr().String(); got != "198.51.100.1:23" {
+		t.Errorf("Expected local address 198.51.100.1:23, got %s", got)
+	}
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(c, b); err != nil || string(b) != "hi" {
+		t.Errorf("Expected data after header, got %q, %v", b, err)
+	}
+}
+
+// proxyV2 builds a PROXY v2 header for TCP over IPv6.
+func proxyV2(cmd byte, src, dst *net.TCPAddr, tlvs ...telnet.ProxyTLV) []byte {
+	var body []byte
+	body = append(body, src.IP.To16()...)
+	body = append(body, dst.IP.To16()...)
+	body = binary.BigEndian.AppendUint16(body, uint16(src.Port))
+	body = binary.BigEndian.AppendUint16(body, uint16(dst.Port))
+	for _, tlv := range tlvs {
+		body = append(body, tlv.Type)
+		body = binary.BigEndian.AppendUint16(body, uint16(len(tlv.Value)))
+		body = append(body, tlv.Value...)
+	}
+	header := append([]byte("\r\n\r\n\x00\r\nQUIT\n"), 0x20|cmd, 0x21)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(body)))
+	return append(header, body...)
+}
+
+func TestProxyConn_V2(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 40000}
+	dst := &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 23}
+	header := proxyV2(1, src, dst,
+		telnet.ProxyTLV{Type: telnet.ProxyTLVAuthority, Value: []byte("example.com")},
+		telnet.ProxyTLV{Type: telnet.ProxyTLVUniqueID, Value: []byte{1, 2, 3}},
+	)
+	c := acceptProxy(t, header, []byte("hi"))
+	if got := c.RemoteAddr().String(); got != src.String() {
+		t.Errorf("Expected remote address %s, got %s", src, got)
+	}
+	if got := c.LocalAddr().String(); got != dst.String() {
+		t.Errorf("Expected local address %s, got %s", dst, got)
+	}
+	if v, ok := c.TLV(telnet.ProxyTLVAuthority); !ok || string(v) != "example.com" {
+		t.Errorf("Expected authority TLV, got %q, %v", v, ok)
+	}
+	if v, ok := c.TLV(telnet.ProxyTLVUniqueID); !ok || !bytes.Equal(v, []byte{1, 2, 3}) {
+		t.Errorf("Expected unique ID TLV, got %v, %v", v, ok)
+	}
+	if _, ok := c.TLV(telnet.ProxyTLVSSL); ok {
+		t.Error("Expected no SSL TLV")
+	}
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(c, b); err != nil || string(b) != "hi" {
+		t.Errorf("Expected data after header, got %q, %v", b, err)
+	}
+
+	// LOCAL connections keep the real addresses.
+	c = acceptProxy(t, proxyV2(0, src, dst), nil)
+	if got := c.RemoteAddr().String(); got == src.String() {
+		t.Errorf("Expected real remote address for LOCAL connection, got %s", got)
+	}
+}
+
+func TestProxyConn_Invalid(t *testing.T) {
+	c := acceptProxy(t, []byte("GET / HTTP/1.1\r\n\r\n"), nil)
+	if err := c.ReadHeader(); !errors.Is(err, telnet.ErrProxyHeader) {
+		t.Errorf("Expected ErrProxyHeader, got %v", err)
+	}
+	if _, err := c.Read(make([]byte, 8)); !errors.Is(err, telnet.ErrProxyHeader) {
+		t.Errorf("Expected ErrProxyHeader from Read, got %v", err)
+	}
+}
+
+func TestServer_Proxy(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := telnet.NewServer("", telnet.HandleFunc(func(c *telnet.Connection) {
+		c.Write([]byte(c.RemoteAddr().String()))
+	}))
+	s.ErrorLog = log.New(io.Discard, "", 0)
+	go s.Serve(&telnet.ProxyListener{Listener: l, HeaderTimeout: time.Second})
+	defer s.Close()
+
+	for header, expected := range map[string]string{
+		"PROXY TCP4 192.0.2.1 198.51.100.1 56324 23\r\n": "192.0.2.1:56324",
+		"not a proxy header\r\n":                         "",
+	} {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Write([]byte(header))
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		b, _ := io.ReadAll(c)
+		c.Close()
+		if string(b) != expected {
+			t.Errorf("Expected %q for %q, got %q", expected, header, b)
+		}
+	}
+}
+
+func TestProxyConn_Short(t *testing.T) {
+	// Neither a minimal v1 header nor short garbage waits for more data.
+	c := acceptProxy(t, []byte("PROXY UNKNOWN\r\n"), nil)
+	start := time.Now()
+	if err := c.ReadHeader(); err != nil {
+		t.Errorf("Expected no error for PROXY UNKNOWN, got %v", err)
+	}
+	c = acceptProxy(t, []byte("HI\r\n"), nil)
+	if err := c.ReadHeader(); !errors.Is(err, telnet.ErrProxyHeader) {
+		t.Errorf("Expected ErrProxyHeader, got %v", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("Expected headers to be read straight away, took %v", d)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+func TestServer_ProxyMalformed(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logBuf syncBuffer
+	m := telnet.NewExpvarMetrics("")
+	s := telnet.NewServer("", telnet.HandleFunc(func(c *telnet.Connection) {}))
+	s.ErrorLog = log.New(&logBuf, "", 0)
+	s.Metrics = m
+	go s.Serve(&telnet.ProxyListener{Listener: l, HeaderTimeout: 5 * time.Second})
+	defer s.Close()
+
+	for i := 0; i < 5; i++ {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Write([]byte("HI\r\n"))
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := io.ReadAll(c); err != nil {
+			t.Errorf("Expected connection %d to be closed, got %v", i, err)
+		}
+		c.Close()
+	}
+	// Refusals are counted and logged before the socket is closed.
+	if got := m.Map.Get("connections_rejected_total").String(); got != `{"proxy": 5}` {
+		t.Errorf("Expected 5 connections rejected for proxy, got %s", got)
+	}
+	if n := strings.Count(logBuf.String(), "PROXY header"); n != 1 {
+		t.Errorf("Expected 1 log line, got %d: %q", n, logBuf.String())
+	}
+}
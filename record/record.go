@@ -0,0 +1,265 @@
+// Package record records telnet sessions, for playback with tools such as
+// asciinema or ttyplay. A Recorder wraps a telnet.Connection; the handler
+// reads from and writes to the Recorder instead of the Connection, and output,
+// and optionally input, is recorded with timestamps. Window size changes
+// reported by the client through NAWS are recorded as resize events.
+//
+// Only what passes through the Recorder is recorded. Output written directly
+// to the Connection is not: that includes a telnet.Banner, and the Server's
+// IdleWarningMessage, PanicMessage, Broadcast and Kick messages. Handlers
+// which need a complete record should write such messages through the
+// Recorder themselves.
+package record
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aprice/telnet"
+)
+
+// Default terminal size, used if the client has not reported its size.
+const (
+	DefaultWidth  = 80
+	DefaultHeight = 24
+)
+
+// Format is a session recording file format.
+type Format int
+
+const (
+	// Asciicast is asciinema's asciicast v2 format: a JSON header line
+	// followed by one JSON event per line. It records output, input and
+	// resizes.
+	Asciicast Format = iota
+	// Ttyrec is the binary ttyrec format read by ttyplay and ipbt. It records
+	// only output; resizes are recorded as xterm resize escape sequences.
+	Ttyrec
+)
+
+// Options configure a Recorder.
+type Options struct {
+	// Input records data read from the client as well as output, if the
+	// format supports it.
+	Input bool
+	// Title and Term are recorded in the asciicast header, if set.
+	Title string
+	Term  string
+}
+
+// Recorder records a session while passing reads and writes through to its
+// Connection. Writes may be made concurrently, as with Connection; reads may
+// not.
+type Recorder struct {
+	conn  *telnet.Connection
+	input bool
+
+	mu     sync.Mutex
+	enc    encoder
+	start  time.Time
+	err    error
+	outBuf []byte // incomplete UTF-8 sequences held back from the recording
+	inBuf  []byte
+}
+
+// encoder writes events in a particular format.
+type encoder interface {
+	output(t time.Duration, b []byte) error
+	input(t time.Duration, b []byte) error
+	resize(t time.Duration, width, height uint16) error
+}
+
+// New starts recording conn to w, in the given format. The recording starts
+// with the client's window size if it has been reported through NAWS. New
+// must be called before conn is read, so that it can watch for size changes;
+// any OnResize already set on the NAWSHandler is still called.
+func New(conn *telnet.Connection, w io.Writer, format Format, opts Options) (*Recorder, error) {
+	r := &Recorder{conn: conn, input: opts.Input, start: time.Now()}
+	width, height := uint16(DefaultWidth), uint16(DefaultHeight)
+	naws, _ := conn.OptionHandlers[telnet.NAWS].(*telnet.NAWSHandler)
+	if naws != nil && naws.Width > 0 && naws.Height > 0 {
+		width, height = naws.Width, naws.Height
+	}
+	switch format {
+	case Asciicast:
+		enc, err := newAsciicast(w, r.start, width, height, opts)
+		if err != nil {
+			return nil, err
+		}
+		r.enc = enc
+	case Ttyrec:
+		r.enc = &ttyrec{w: w, start: r.start}
+		r.input = false
+	default:
+		return nil, fmt.Errorf("record: unknown format %d", format)
+	}
+	if naws != nil {
+		prev := naws.OnResize
+		naws.OnResize = func(width, height uint16) {
+			if prev != nil {
+				prev(width, height)
+			}
+			r.resize(width, height)
+		}
+	}
+	return r, nil
+}
+
+// Read reads from the Connection, recording what is read if input is being
+// recorded.
+func (r *Recorder) Read(b []byte) (int, error) {
+	n, err := r.conn.Read(b)
+	if n > 0 && r.input {
+		r.record(b[:n], &r.inBuf, r.enc.input)
+	}
+	return n, err
+}
+
+// Write records b as output and writes it to the Connection.
+func (r *Recorder) Write(b []byte) (int, error) {
+	r.record(b, &r.outBuf, r.enc.output)
+	return r.conn.Write(b)
+}
+
+// Err returns the first error writing the recording, if any. Recording stops
+// after an error, but the session is unaffected.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Close stops recording, and flushes any output held back because it ended in
+// an incomplete UTF-8 sequence. It does not close the Connection or the
+// recording's Writer.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t := time.Since(r.start)
+	if len(r.outBuf) > 0 && r.err == nil {
+		r.err = r.enc.output(t, r.outBuf)
+	}
+	if len(r.inBuf) > 0 && r.err == nil {
+		r.err = r.enc.input(t, r.inBuf)
+	}
+	r.outBuf, r.inBuf = nil, nil
+	err := r.err
+	if r.err == nil {
+		r.err = errRecorderClosed
+	}
+	return err
+}
+
+var errRecorderClosed = errors.New("record: recorder closed")
+
+// record writes b with the encoding function, prefixed by any held back
+// bytes, holding back a trailing incomplete UTF-8 sequence so that multibyte
+// characters split across writes are recorded intact.
+func (r *Recorder) record(b []byte, held *[]byte, write func(time.Duration, []byte) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	data := append(*held, b...)
+	cut := len(data) - incompleteUTF8(data)
+	*held = append([]byte(nil), data[cut:]...)
+	if cut > 0 {
+		r.err = write(time.Since(r.start), data[:cut])
+	}
+}
+
+func (r *Recorder) resize(width, height uint16) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = r.enc.resize(time.Since(r.start), width, height)
+	}
+}
+
+// incompleteUTF8 returns the length of the incomplete UTF-8 sequence at the
+// end of b, if any.
+func incompleteUTF8(b []byte) int {
+	for i := 1; i < utf8.UTFMax && i <= len(b); i++ {
+		c := b[len(b)-i]
+		if c < 0x80 {
+			return 0
+		}
+		if utf8.RuneStart(c) {
+			if utf8.FullRune(b[len(b)-i:]) {
+				return 0
+			}
+			return i
+		}
+	}
+	return 0
+}
+
+// asciicast writes asciicast v2.
+type asciicast struct {
+	enc *json.Encoder
+}
+
+func newAsciicast(w io.Writer, start time.Time, width, height uint16, opts Options) (*asciicast, error) {
+	header := struct {
+		Version   int               `json:"version"`
+		Width     uint16            `json:"width"`
+		Height    uint16            `json:"height"`
+		Timestamp int64             `json:"timestamp"`
+		Title     string            `json:"title,omitempty"`
+		Env       map[string]string `json:"env,omitempty"`
+	}{Version: 2, Width: width, Height: height, Timestamp: start.Unix(), Title: opts.Title}
+	if opts.Term != "" {
+		header.Env = map[string]string{"TERM": opts.Term}
+	}
+	a := &asciicast{enc: json.NewEncoder(w)}
+	a.enc.SetEscapeHTML(false)
+	return a, a.enc.Encode(header)
+}
+
+func (a *asciicast) event(t time.Duration, kind, data string) error {
+	return a.enc.Encode([]interface{}{json.Number(fmt.Sprintf("%.6f", t.Seconds())), kind, data})
+}
+
+func (a *asciicast) output(t time.Duration, b []byte) error {
+	return a.event(t, "o", string(b))
+}
+
+func (a *asciicast) input(t time.Duration, b []byte) error {
+	return a.event(t, "i", string(b))
+}
+
+func (a *asciicast) resize(t time.Duration, width, height uint16) error {
+	return a.event(t, "r", fmt.Sprintf("%dx%d", width, height))
+}
+
+// ttyrec writes ttyrec frames: a 12 byte little-endian header of the Unix
+// time in seconds and microseconds and the data length, followed by the data.
+type ttyrec struct {
+	w     io.Writer
+	start time.Time
+}
+
+func (r *ttyrec) output(t time.Duration, b []byte) error {
+	at := r.start.Add(t)
+	frame := make([]byte, 12, 12+len(b))
+	binary.LittleEndian.PutUint32(frame, uint32(at.Unix()))
+	binary.LittleEndian.PutUint32(frame[4:], uint32(at.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(frame[8:], uint32(len(b)))
+	_, err := r.w.Write(append(frame, b...))
+	return err
+}
+
+func (r *ttyrec) input(t time.Duration, b []byte) error {
+	return nil
+}
+
+func (r *ttyrec) resize(t time.Duration, width, height uint16) error {
+	return r.output(t, []byte(fmt.Sprintf("\033[8;%d;%dt", height, width)))
+}
@@ -0,0 +1,154 @@
+package record_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aprice/telnet"
+	"github.com/aprice/telnet/record"
+)
+
+// nawsConn returns a server Connection with NAWS negotiated at 100x30, and the
+// client end of it.
+func nawsConn(t *testing.T) (*telnet.Connection, net.Conn) {
+	t.Helper()
+	client, server := net.Pipe()
+	go func() {
+		client.Read(make([]byte, 3)) // IAC DO NAWS
+		client.Write([]byte{255, 251, 31, 255, 250, 31, 0, 100, 0, 30, 255, 240})
+	}()
+	conn := telnet.NewConnection(server, []telnet.Option{telnet.NAWSOption})
+	if !conn.AwaitNegotiation(time.Second) {
+		t.Fatal("Expected NAWS to be negotiated")
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		client.Close()
+	})
+	return conn, client
+}
+
+func TestAsciicast(t *testing.T) {
+	conn, client := nawsConn(t)
+	var buf bytes.Buffer
+	r, err := record.New(conn, &buf, record.Asciicast, record.Options{Input: true, Term: "xterm"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go io.Copy(io.Discard, client)
+
+	snowman := []byte("☃")
+	r.Write([]byte("hi "))
+	r.Write(snowman[:1]) // split multibyte character
+	r.Write(snowman[1:])
+	go client.Write([]byte{'x', 255, 250, 31, 0, 120, 0, 40, 255, 240})
+	b := make([]byte, 8)
+	if n, err := r.Read(b); err != nil || string(b[:n]) != "x" {
+		t.Fatalf("Expected x, got %q, %v", b[:n], err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sc := bufio.NewScanner(&buf)
+	sc.Scan()
+	var header map[string]interface{}
+	if err := json.Unmarshal(sc.Bytes(), &header); err != nil {
+		t.Fatal(err)
+	}
+	if header["version"] != 2.0 || header["width"] != 100.0 || header["height"] != 30.0 {
+		t.Errorf("Expected v2 100x30 header, got %v", header)
+	}
+	var events []string
+	for sc.Scan() {
+		var ev []interface{}
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, ev[1].(string)+" "+ev[2].(string))
+	}
+	// The resize is handled while reading, before the read returns.
+	expected := "o hi ,o ☃,r 120x40,i x"
+	if strings.Join(events, ",") != expected {
+		t.Errorf("Expected events %q, got %q", expected, events)
+	}
+}
+
+func TestChainsOnResize(t *testing.T) {
+	conn, client := nawsConn(t)
+	naws := conn.OptionHandlers[telnet.NAWS].(*telnet.NAWSHandler)
+	var resized []uint16
+	naws.OnResize = func(width, height uint16) {
+		resized = append(resized, width, height)
+	}
+	var buf bytes.Buffer
+	r, err := record.New(conn, &buf, record.Asciicast, record.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go client.Write([]byte{255, 250, 31, 0, 120, 0, 40, 255, 240, 'x'})
+	if _, err := r.Read(make([]byte, 8)); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	if len(resized) != 2 || resized[0] != 120 || resized[1] != 40 {
+		t.Errorf("Expected existing OnResize to see 120x40, got %v", resized)
+	}
+	if !strings.Contains(buf.String(), `"r","120x40"`) {
+		t.Errorf("Expected resize to be recorded, got %q", buf.String())
+	}
+}
+
+func TestUnrecordedOutput(t *testing.T) {
+	conn, client := nawsConn(t)
+	var buf bytes.Buffer
+	r, err := record.New(conn, &buf, record.Asciicast, record.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go io.Copy(io.Discard, client)
+	// Writes made directly to the Connection bypass the Recorder.
+	conn.Write([]byte("direct"))
+	r.Write([]byte("recorded"))
+	r.Close()
+	if strings.Contains(buf.String(), "direct") {
+		t.Errorf("Expected direct writes not to be recorded, got %q", buf.String())
+	}
+	if !strings.Contains(buf.String(), "recorded") {
+		t.Errorf("Expected writes through the Recorder to be recorded, got %q", buf.String())
+	}
+}
+
+func TestTtyrec(t *testing.T) {
+	conn, client := nawsConn(t)
+	var buf bytes.Buffer
+	r, err := record.New(conn, &buf, record.Ttyrec, record.Options{Input: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go io.Copy(io.Discard, client)
+	r.Write([]byte("hello"))
+	go client.Write([]byte("ignored"))
+	r.Read(make([]byte, 8))
+	r.Close()
+
+	var frames []string
+	for rec := buf.Bytes(); len(rec) > 0; {
+		if len(rec) < 12 {
+			t.Fatalf("Expected frame header, got %v", rec)
+		}
+		n := int(binary.LittleEndian.Uint32(rec[8:]))
+		frames = append(frames, string(rec[12:12+n]))
+		rec = rec[12+n:]
+	}
+	if len(frames) != 1 || frames[0] != "hello" {
+		t.Errorf("Expected only output to be recorded, got %q", frames)
+	}
+}
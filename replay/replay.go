@@ -0,0 +1,199 @@
+// Package replay plays sessions recorded by the record package, in asciicast
+// v2 or ttyrec format, back to a telnet Connection or any other Writer, with
+// their original timing.
+package replay
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frame is a chunk of recorded output.
+type Frame struct {
+	// Time is when the frame was output, relative to the start of the
+	// recording.
+	Time time.Duration
+	// Data is the output, including any resize escape sequence.
+	Data []byte
+}
+
+// Source reads the frames of a recording in order. Next returns io.EOF after
+// the last frame.
+type Source interface {
+	Next() (Frame, error)
+}
+
+// ErrFormat is returned for recordings in an unrecognized format.
+var ErrFormat = errors.New("replay: unrecognized recording format")
+
+// NewSource returns a Source reading the recording from r, detecting whether
+// it is asciicast v2 or ttyrec. Asciicast input events are skipped, and resize
+// events are played as xterm resize escape sequences, as the record package
+// records them in ttyrec.
+func NewSource(r io.Reader) (Source, error) {
+	br := bufio.NewReader(r)
+	first, err := br.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] == '{' {
+		return newAsciicast(br)
+	}
+	return &ttyrec{r: br}, nil
+}
+
+// Options control playback.
+type Options struct {
+	// Speed multiplies the playback speed; 2 plays twice as fast. If zero,
+	// the recording plays at its original speed.
+	Speed float64
+	// MaxIdle, if set, caps the pause between frames, so long idle periods in
+	// the recording are skipped over.
+	MaxIdle time.Duration
+}
+
+// Play writes the frames of src to w with their original timing, adjusted by
+// opts, until the recording ends, ctx is done, or writing fails.
+func Play(ctx context.Context, w io.Writer, src Source, opts Options) error {
+	speed := opts.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+	var last time.Duration
+	for {
+		f, err := src.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		delay := time.Duration(float64(f.Time-last) / speed)
+		if opts.MaxIdle > 0 && delay > opts.MaxIdle {
+			delay = opts.MaxIdle
+		}
+		last = f.Time
+		if delay > 0 {
+			t := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return ctx.Err()
+			case <-t.C:
+			}
+		} else if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := w.Write(f.Data); err != nil {
+			return err
+		}
+	}
+}
+
+// PlayFile is a convenience which detects the format of the recording read
+// from r and plays it to w with Play.
+func PlayFile(ctx context.Context, w io.Writer, r io.Reader, opts Options) error {
+	src, err := NewSource(r)
+	if err != nil {
+		return err
+	}
+	return Play(ctx, w, src, opts)
+}
+
+// asciicast reads asciicast v2 events, one JSON array per line.
+type asciicast struct {
+	dec *json.Decoder
+}
+
+func newAsciicast(r io.Reader) (*asciicast, error) {
+	dec := json.NewDecoder(r)
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := dec.Decode(&header); err != nil {
+		return nil, fmt.Errorf("replay: reading asciicast header: %w", err)
+	}
+	if header.Version != 2 {
+		return nil, fmt.Errorf("%w: asciicast version %d", ErrFormat, header.Version)
+	}
+	dec.UseNumber()
+	return &asciicast{dec: dec}, nil
+}
+
+func (a *asciicast) Next() (Frame, error) {
+	for {
+		var ev []interface{}
+		if err := a.dec.Decode(&ev); err != nil {
+			return Frame{}, err
+		}
+		if len(ev) != 3 {
+			return Frame{}, fmt.Errorf("%w: asciicast event %v", ErrFormat, ev)
+		}
+		ts, ok1 := ev[0].(json.Number)
+		kind, ok2 := ev[1].(string)
+		data, ok3 := ev[2].(string)
+		secs, err := ts.Float64()
+		if !ok1 || !ok2 || !ok3 || err != nil {
+			return Frame{}, fmt.Errorf("%w: asciicast event %v", ErrFormat, ev)
+		}
+		t := time.Duration(secs * float64(time.Second))
+		switch kind {
+		case "o":
+			return Frame{Time: t, Data: []byte(data)}, nil
+		case "r":
+			width, height, ok := strings.Cut(data, "x")
+			if !ok {
+				continue
+			}
+			if _, err := strconv.Atoi(width); err != nil {
+				continue
+			}
+			if _, err := strconv.Atoi(height); err != nil {
+				continue
+			}
+			return Frame{Time: t, Data: []byte("\033[8;" + height + ";" + width + "t")}, nil
+		}
+		// Input and other events are not played back.
+	}
+}
+
+// maxTtyrecFrame is the largest ttyrec frame accepted, so that a corrupt
+// length does not cause a huge allocation.
+const maxTtyrecFrame = 1 << 24
+
+// ttyrec reads ttyrec frames; times are made relative to the first frame.
+type ttyrec struct {
+	r     io.Reader
+	start time.Time
+}
+
+func (r *ttyrec) Next() (Frame, error) {
+	var hdr [12]byte
+	if _, err := io.ReadFull(r.r, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = fmt.Errorf("%w: truncated ttyrec header", ErrFormat)
+		}
+		return Frame{}, err
+	}
+	at := time.Unix(int64(binary.LittleEndian.Uint32(hdr[:])), int64(binary.LittleEndian.Uint32(hdr[4:]))*1000)
+	if r.start.IsZero() {
+		r.start = at
+	}
+	n := binary.LittleEndian.Uint32(hdr[8:])
+	if n > maxTtyrecFrame {
+		return Frame{}, fmt.Errorf("%w: ttyrec frame of %d bytes", ErrFormat, n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return Frame{}, fmt.Errorf("%w: truncated ttyrec frame", ErrFormat)
+	}
+	return Frame{Time: at.Sub(r.start), Data: data}, nil
+}
@@ -0,0 +1,87 @@
+package replay_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aprice/telnet/replay"
+)
+
+const cast = `{"version": 2, "width": 80, "height": 24}
+[0.000000, "o", "hello "]
+[0.050000, "i", "x"]
+[0.100000, "r", "100x30"]
+[0.200000, "o", "world"]
+`
+
+func TestPlay_Asciicast(t *testing.T) {
+	var out bytes.Buffer
+	start := time.Now()
+	if err := replay.PlayFile(context.Background(), &out, strings.NewReader(cast), replay.Options{Speed: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Expected playback at double speed to take 100ms, took %v", elapsed)
+	}
+	if expected := "hello \033[8;30;100tworld"; out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
+
+// ttyrecFrame encodes a ttyrec frame at the given Unix time.
+func ttyrecFrame(sec, usec uint32, data string) []byte {
+	frame := make([]byte, 12)
+	binary.LittleEndian.PutUint32(frame, sec)
+	binary.LittleEndian.PutUint32(frame[4:], usec)
+	binary.LittleEndian.PutUint32(frame[8:], uint32(len(data)))
+	return append(frame, data...)
+}
+
+func TestPlay_Ttyrec(t *testing.T) {
+	rec := append(ttyrecFrame(1700000000, 900000, "hello "), ttyrecFrame(1700003600, 0, "world")...)
+	src, err := replay.NewSource(bytes.NewReader(rec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	start := time.Now()
+	// The hour-long pause is capped.
+	if err := replay.Play(context.Background(), &out, src, replay.Options{MaxIdle: 10 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected idle time to be capped, took %v", elapsed)
+	}
+	if out.String() != "hello world" {
+		t.Errorf("Expected %q, got %q", "hello world", out.String())
+	}
+
+	src, err = replay.NewSource(bytes.NewReader(rec[:20]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = src.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = src.Next(); !errors.Is(err, replay.ErrFormat) {
+		t.Errorf("Expected ErrFormat for truncated header, got %v", err)
+	}
+}
+
+func TestPlay_Cancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var out bytes.Buffer
+	err := replay.PlayFile(ctx, &out, strings.NewReader(cast), replay.Options{})
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if out.String() != "hello " {
+		t.Errorf("Expected only the first frame, got %q", out.String())
+	}
+}
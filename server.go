@@ -1,13 +1,23 @@
 package telnet
 
 import (
+	"context"
+	"log"
 	"net"
+	"runtime/debug"
+	"sync"
+	"time"
 )
 
 // Option functions add handling of a telnet option to a Server. The Option
 // function takes a connection (which it can store but needn't) and returns a
 // Negotiator; it is up to the Option function whether a single instance of
 // the Negotiator is reused or if a new instance is created for each connection.
+//
+// An Option may instead return nil if it only configures the connection, as
+// TraceOption and MetricsOption do. Nothing is then added to OptionHandlers,
+// so the Connection refuses the option if the peer offers it, just as it does
+// for options with no Option at all.
 type Option func(c *Connection) Negotiator
 
 // Handler is a telnet connection handler. The Handler passed to a server will
@@ -28,39 +38,316 @@ func (f HandleFunc) HandleTelnet(conn *Connection) {
 // Server listens for telnet connections.
 type Server struct {
 	// Address is the addres the Server listens on.
-	Address  string
-	handler  Handler
-	options  []Option
-	listener net.Listener
-	quitting bool
+	Address string
+	handler Handler
+	options []Option
+
+	// Installed by Use, and wrapped around handler by Serve.
+	middleware []Middleware
+
+	// MaxConnections limits the number of connections handled at once. If
+	// zero, there is no limit.
+	MaxConnections int
+	// MaxConnectionsPerIP limits the number of connections from a single
+	// remote address handled at once. If zero, there is no limit.
+	MaxConnectionsPerIP int
+	// ConnectRate limits the rate of new connections from a single remote
+	// address, in connections per second, allowing bursts of up to
+	// ConnectBurst. If zero, there is no limit.
+	ConnectRate  float64
+	ConnectBurst int
+	// OverflowHandler is called for connections refused because of the above
+	// limits, before they are closed. No options are negotiated with them,
+	// and the handler has five seconds to write to the connection. At most
+	// 64 refused connections are handled at once; any beyond that are closed
+	// without calling the handler. If nil, DefaultOverflowHandler is used.
+	OverflowHandler Handler
+
+	// ConnState, if set, is called as each connection handled by the Server
+	// changes state. It is called from the handler goroutine, or from the
+	// goroutine reading from the connection for StateIdle and StateActive,
+	// one change at a time; the hook must not block for long. StateClosed is
+	// always reported last. Connections refused by the limits above are not
+	// reported.
+	ConnState func(*Connection, ConnState)
+	// OnConnect, if set, is called when a connection is accepted, before any
+	// options are offered.
+	OnConnect func(*Connection)
+	// OnDisconnect, if set, is called once a connection has been closed,
+	// with how long it was connected.
+	OnDisconnect func(*Connection, time.Duration)
+
+	// IdleTimeout, if set, closes connections which have not received any
+	// data from the client for that long. If IdleWarning is also set, the
+	// client is sent IdleWarningMessage (or DefaultIdleWarningMessage) that
+	// long before it is disconnected; an IdleWarning no shorter than
+	// IdleTimeout is ignored.
+	IdleTimeout        time.Duration
+	IdleWarning        time.Duration
+	IdleWarningMessage string
+	// KeepaliveInterval, if set, starts a Keepalive of KeepaliveMode on every
+	// connection once its options have been offered.
+	KeepaliveInterval time.Duration
+	KeepaliveMode     KeepaliveMode
+
+	// NegotiationTimeout, if set, makes the Server wait up to that long for
+	// the client to settle every option offered before calling the Handler;
+	// see Connection.AwaitNegotiation.
+	NegotiationTimeout time.Duration
+
+	// Metrics, if set, receives counts of the Server's connections and their
+	// activity; see ExpvarMetrics.
+	Metrics Metrics
+
+	// ErrorLog is where the Server logs errors, such as panics recovered from
+	// handlers. If nil, errors are logged using the log package's standard
+	// logger.
+	ErrorLog *log.Logger
+	// PanicMessage, if set, is written to the client before closing a
+	// connection whose handler panicked.
+	PanicMessage string
+	// BroadcastTimeout bounds how long Broadcast and Kick wait for each
+	// client to accept a message; clients slower than this are disconnected.
+	// If zero, DefaultBroadcastTimeout is used.
+	BroadcastTimeout time.Duration
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	quitting  bool
+	closed    bool // set when remaining connections are being force-closed
+	active    int  // connections accepted whose handlers have not returned
+	serving   int  // Serve loops which have not returned
+	conns     map[*Connection]*Session
+	sessions  map[uint64]*Session
+	lastID    uint64
+
+	admitted  int            // connections admitted under the limits
+	perIP     map[string]int // admitted connections by remote host
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	// refused connections being passed to the OverflowHandler
+	overflowing int
+
+	// When a bad PROXY header was last logged, and how many have not been
+	// logged since.
+	proxyLogged     time.Time
+	proxySuppressed int
+
+	// Parent of every connection's Context; cancelled by Stop.
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 // NewServer constructs a new telnet server.
 func NewServer(addr string, handler Handler, options ...Option) *Server {
-	return &Server{Address: addr, handler: handler, options: options}
+	s := &Server{
+		Address:   addr,
+		handler:   handler,
+		options:   options,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[*Connection]*Session),
+		sessions:  make(map[uint64]*Session),
+		perIP:     make(map[string]int),
+		buckets:   make(map[string]*bucket),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s
 }
 
-// Serve runs the telnet server. This function does not return and
-// should probably be run in a goroutine.
+// Serve runs the telnet server. This function does not return until the
+// Server is stopped, and should probably be run in a goroutine. Serve may be
+// called concurrently with several listeners, all of which are closed when the
+// Server is stopped; Address is set to the address of the first.
 func (s *Server) Serve(l net.Listener) error {
-	s.listener = l
-	s.Address = s.listener.Addr().String()
+	s.mu.Lock()
+	if s.quitting {
+		s.mu.Unlock()
+		l.Close()
+		return nil
+	}
+	if len(s.listeners) == 0 {
+		s.Address = l.Addr().String()
+	}
+	s.listeners[l] = struct{}{}
+	s.serving++
+	handler := Chain(s.handler, s.middleware...)
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.serving--
+		s.mu.Unlock()
+	}()
+	var tempDelay time.Duration
 	for {
-		c, err := s.listener.Accept()
+		c, err := l.Accept()
 		if err != nil {
-			if s.quitting {
+			if s.shuttingDown() {
 				return nil
 			}
+			// Back off on temporary errors such as running out of file
+			// descriptors, as net/http does, rather than giving up.
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else {
+					tempDelay *= 2
+				}
+				if tempDelay > time.Second {
+					tempDelay = time.Second
+				}
+				s.logf("telnet: accept error: %v; retrying in %v", err, tempDelay)
+				t := time.NewTimer(tempDelay)
+				select {
+				case <-t.C:
+				case <-s.ctx.Done():
+					t.Stop()
+				}
+				continue
+			}
 			return err
 		}
-		conn := NewConnection(c, s.options)
-		go func() {
-			s.handler.HandleTelnet(conn)
-			conn.Conn.Close()
-		}()
+		tempDelay = 0
+		s.mu.Lock()
+		if s.quitting {
+			// Accepted as the Server was stopped; too late to handle.
+			s.mu.Unlock()
+			c.Close()
+			return nil
+		}
+		s.active++
+		s.mu.Unlock()
+		go s.serveConn(c, handler)
+	}
+}
+
+// serveConn negotiates and handles a single accepted connection.
+func (s *Server) serveConn(c net.Conn, handler Handler) {
+	var conn *Connection
+	var start time.Time
+	// Installed first, so that a panic anywhere below, including in the
+	// OverflowHandler or OnConnect, is recovered and the socket is closed.
+	// Once conn exists, StateClosed and OnDisconnect are always reported.
+	defer func() {
+		if err := recover(); err != nil {
+			s.logf("telnet: panic serving %v: %v\n%s", c.RemoteAddr(), err, debug.Stack())
+			if conn != nil && s.PanicMessage != "" {
+				conn.SetWriteDeadline(time.Now().Add(overflowWriteTimeout))
+				conn.Write([]byte(s.PanicMessage))
+			}
+		}
+		if conn == nil {
+			c.Close()
+		} else {
+			conn.Close()
+			conn.setState(StateClosed)
+			d := time.Since(start)
+			if s.Metrics != nil {
+				s.Metrics.SessionEnded(d)
+			}
+			if s.OnDisconnect != nil {
+				s.OnDisconnect(conn, d)
+			}
+		}
+		s.mu.Lock()
+		s.active--
+		s.mu.Unlock()
+	}()
+	if pc, ok := c.(*ProxyConn); ok {
+		if err := pc.ReadHeader(); err != nil {
+			s.logProxyError(pc.Conn.RemoteAddr(), err)
+			if s.Metrics != nil {
+				s.Metrics.ConnectionRejected("proxy")
+			}
+			return
+		}
+	}
+	host := remoteHost(c.RemoteAddr())
+	if !s.admit(host) {
+		if s.Metrics != nil {
+			s.Metrics.ConnectionRejected("limit")
+		}
+		s.overflow(c)
+		return
+	}
+	if s.Metrics != nil {
+		s.Metrics.ConnectionAccepted()
+	}
+	defer s.release(host)
+	start = time.Now()
+	conn = newConnection(s.ctx, c)
+	conn.stateHook = s.ConnState
+	conn.metrics = s.Metrics
+	if s.ConnState != nil {
+		// Connections start out in StateNew, so it is never a change.
+		s.ConnState(conn, StateNew)
+	}
+	if s.OnConnect != nil {
+		s.OnConnect(conn)
+	}
+	if !s.track(conn, true) {
+		return
+	}
+	defer s.track(conn, false)
+	conn.setState(StateNegotiating)
+	conn.negotiate(s.options)
+	if s.NegotiationTimeout > 0 {
+		conn.AwaitNegotiation(s.NegotiationTimeout)
+	}
+	if s.KeepaliveInterval > 0 {
+		conn.Keepalive(s.KeepaliveInterval, s.KeepaliveMode)
+	}
+	if s.IdleTimeout > 0 {
+		msg := s.IdleWarningMessage
+		if msg == "" {
+			msg = DefaultIdleWarningMessage
+		}
+		done := make(chan struct{})
+		defer close(done)
+		go conn.watchIdle(done, s.IdleTimeout, s.IdleWarning, msg)
+	}
+	conn.setState(StateActive)
+	handler.HandleTelnet(conn)
+}
+
+// track adds or removes conn from the set of active connections, registering
+// its Session. It returns false if conn should not be handled, because the
+// Server is being closed.
+func (s *Server) track(conn *Connection, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !add {
+		if sess, ok := s.conns[conn]; ok {
+			delete(s.sessions, sess.ID)
+		}
+		delete(s.conns, conn)
+		return true
+	}
+	if s.closed {
+		return false
+	}
+	s.lastID++
+	sess := &Session{ID: s.lastID, Conn: conn, Started: time.Now()}
+	s.conns[conn] = sess
+	s.sessions[sess.ID] = sess
+	return true
+}
+
+// logf logs to the Server's ErrorLog, or the standard logger if it has none.
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
 	}
 }
 
+func (s *Server) shuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.quitting
+}
+
 // ListenAndServe runs the telnet server by creating a new Listener using the
 // current Server.Address, and then calling Serve().
 func (s *Server) ListenAndServe() error {
@@ -72,12 +359,61 @@ func (s *Server) ListenAndServe() error {
 	return s.Serve(l)
 }
 
-// Stop the telnet server. This stops listening for new connections, but does
-// not affect any active connections already opened.
+// Stop the telnet server. This stops listening for new connections. Active
+// connections already opened are not closed, but their Context is cancelled,
+// signaling handlers to finish up.
 func (s *Server) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if s.quitting {
 		return
 	}
 	s.quitting = true
-	s.listener.Close()
+	s.cancel()
+	for l := range s.listeners {
+		l.Close()
+	}
+}
+
+// shutdownPollInterval is how often Shutdown checks for active connections.
+const shutdownPollInterval = 10 * time.Millisecond
+
+// Shutdown gracefully shuts down the server, in the manner of
+// http.Server.Shutdown. It stops listening for new connections, cancels the
+// Context of every active connection to notify its handler, and waits for the
+// handlers, and every call to Serve, to return. If ctx is done first, any remaining connections are
+// closed and ctx.Err() is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.Stop()
+	t := time.NewTicker(shutdownPollInterval)
+	defer t.Stop()
+	for {
+		s.mu.Lock()
+		// Serve loops are waited for too, as one may yet count a
+		// connection it accepted just before the Server was stopped.
+		idle := s.active == 0 && s.serving == 0
+		s.mu.Unlock()
+		if idle {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Close immediately stops the server and closes all active connections,
+// without waiting for their handlers to return.
+func (s *Server) Close() error {
+	s.Stop()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return nil
 }
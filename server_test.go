@@ -1,6 +1,13 @@
 package telnet_test
 
 import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net"
+	"reflect"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -42,3 +49,533 @@ func TestServer_ListenAndServe(t *testing.T) {
 	s.Stop()
 	wg.Wait()
 }
+
+func TestServer_Shutdown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	finished := make(chan struct{})
+	s := telnet.NewServer("", telnet.HandleFunc(func(c *telnet.Connection) {
+		close(started)
+		<-c.Context().Done()
+		c.Write([]byte("Goodbye!"))
+		close(finished)
+	}))
+	go s.Serve(l)
+
+	client, err := telnet.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go io.Copy(io.Discard, client)
+	if err := s.Shutdown(ctx); err != nil {
+		t.Errorf("Expected clean shutdown, got %v", err)
+	}
+	select {
+	case <-finished:
+	default:
+		t.Error("Expected Shutdown to wait for the handler to return")
+	}
+	if _, err := net.Dial("tcp", l.Addr().String()); err == nil {
+		t.Error("Expected listener to be closed after Shutdown")
+	}
+}
+
+// lateListener accepts a single connection only once it has been closed, as
+// when a connection arrives just as a Server is stopped.
+type lateListener struct {
+	net.Listener
+	closed   chan struct{}
+	once     sync.Once
+	accepted bool
+}
+
+func (l *lateListener) Accept() (net.Conn, error) {
+	<-l.closed
+	if l.accepted {
+		return nil, net.ErrClosed
+	}
+	l.accepted = true
+	// Give Shutdown a chance to look before the connection is counted.
+	time.Sleep(20 * time.Millisecond)
+	c, _ := net.Pipe()
+	return c, nil
+}
+
+func (l *lateListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return l.Listener.Close()
+}
+
+func TestServer_ShutdownAcceptRace(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &lateListener{Listener: inner, closed: make(chan struct{})}
+	var mu sync.Mutex
+	handled := false
+	s := telnet.NewServer("", telnet.HandleFunc(func(c *telnet.Connection) {
+		mu.Lock()
+		handled = true
+		mu.Unlock()
+	}))
+	served := make(chan struct{})
+	go func() {
+		s.Serve(l)
+		close(served)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-served:
+	default:
+		t.Error("Expected Shutdown to wait for Serve to return")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if handled {
+		t.Error("Expected connection accepted after Stop not to be handled")
+	}
+}
+
+func TestServer_ShutdownTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	readErr := make(chan error, 1)
+	s := telnet.NewServer("", telnet.HandleFunc(func(c *telnet.Connection) {
+		close(started)
+		// Ignores the context; only closing the connection ends the read.
+		_, err := c.Read(make([]byte, 8))
+		readErr <- err
+	}))
+	go s.Serve(l)
+
+	client, err := telnet.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	select {
+	case err := <-readErr:
+		if err == nil {
+			t.Error("Expected handler read to fail after forced close")
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected connection to be closed after Shutdown timed out")
+	}
+}
+
+// dialAndRead connects to addr and returns everything received until the
+// server closes the connection.
+func dialAndRead(t *testing.T, addr string) string {
+	t.Helper()
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(time.Second))
+	b, err := io.ReadAll(c)
+	if err != nil {
+		t.Error(err)
+	}
+	return string(b)
+}
+
+func TestServer_MaxConnections(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	s := telnet.NewServer("", telnet.HandleFunc(func(c *telnet.Connection) {
+		close(started)
+		<-c.Context().Done()
+	}))
+	s.MaxConnections = 1
+	go s.Serve(l)
+	defer s.Close()
+
+	client, err := telnet.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	<-started
+	if got := dialAndRead(t, l.Addr().String()); got != "Server is full, please try again later.\r\n" {
+		t.Errorf("Expected server full message, got %q", got)
+	}
+}
+
+func TestServer_MaxConnectionsPerIP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{}, 2)
+	s := telnet.NewServer("", telnet.HandleFunc(func(c *telnet.Connection) {
+		started <- struct{}{}
+		<-c.Context().Done()
+	}))
+	s.MaxConnectionsPerIP = 2
+	go s.Serve(l)
+	defer s.Close()
+
+	for i := 0; i < 2; i++ {
+		client, err := telnet.Dial(l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		<-started
+	}
+	if got := dialAndRead(t, l.Addr().String()); got != "Server is full, please try again later.\r\n" {
+		t.Errorf("Expected server full message, got %q", got)
+	}
+}
+
+func TestServer_OverflowBound(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := telnet.NewServer("", telnet.HandleFunc(func(c *telnet.Connection) {
+		<-c.Context().Done()
+	}))
+	s.MaxConnections = 1
+	entered := make(chan struct{}, 64)
+	release := make(chan struct{})
+	s.OverflowHandler = telnet.HandleFunc(func(c *telnet.Connection) {
+		entered <- struct{}{}
+		<-release
+	})
+	go s.Serve(l)
+	defer s.Close()
+	defer close(release)
+
+	client, err := telnet.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	// Fill every overflow slot with a handler which does not return.
+	for i := 0; i < 64; i++ {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		<-entered
+	}
+	start := time.Now()
+	if got := dialAndRead(t, l.Addr().String()); got != "" {
+		t.Errorf("Expected connection closed without a message, got %q", got)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("Expected connection closed straight away, took %v", d)
+	}
+}
+
+type tempError struct{}
+
+func (tempError) Error() string   { return "temporary failure" }
+func (tempError) Timeout() bool   { return false }
+func (tempError) Temporary() bool { return true }
+
+// flakyListener fails its first few Accepts with a temporary error.
+type flakyListener struct {
+	net.Listener
+	mu       sync.Mutex
+	failures int
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	if l.failures > 0 {
+		l.failures--
+		l.mu.Unlock()
+		return nil, tempError{}
+	}
+	l.mu.Unlock()
+	return l.Listener.Accept()
+}
+
+func TestServer_AcceptRetry(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &flakyListener{Listener: inner, failures: 3}
+	s := telnet.NewServer("", telnet.HandleFunc(func(c *telnet.Connection) {
+		c.Write([]byte("Hello!"))
+	}))
+	s.ErrorLog = log.New(io.Discard, "", 0)
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(l) }()
+	defer s.Close()
+
+	if got := dialAndRead(t, inner.Addr().String()); got != "Hello!" {
+		t.Errorf("Expected %q after temporary errors, got %q", "Hello!", got)
+	}
+	select {
+	case err := <-served:
+		t.Errorf("Serve returned early: %v", err)
+	default:
+	}
+}
+
+func TestServer_ConnectRate(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := telnet.NewServer("", telnet.HandleFunc(func(c *telnet.Connection) {
+		c.Write([]byte("Hello!"))
+	}))
+	s.ConnectRate = 0.01
+	s.ConnectBurst = 2
+	s.OverflowHandler = telnet.HandleFunc(func(c *telnet.Connection) {
+		c.Write([]byte("Slow down!"))
+	})
+	go s.Serve(l)
+	defer s.Close()
+
+	for i, expected := range []string{"Hello!", "Hello!", "Slow down!"} {
+		if got := dialAndRead(t, l.Addr().String()); got != expected {
+			t.Errorf("Connection %d: expected %q, got %q", i, expected, got)
+		}
+	}
+}
+
+func TestServer_ConnState(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var states []telnet.ConnState
+	disconnected := make(chan time.Duration, 1)
+	s := telnet.NewServer("", telnet.HandleFunc(func(c *telnet.Connection) {
+		c.Read(make([]byte, 8))
+	}))
+	s.ConnState = func(c *telnet.Connection, state telnet.ConnState) {
+		mu.Lock()
+		states = append(states, state)
+		mu.Unlock()
+	}
+	connected := false
+	s.OnConnect = func(c *telnet.Connection) {
+		connected = true
+	}
+	s.OnDisconnect = func(c *telnet.Connection, d time.Duration) {
+		disconnected <- d
+	}
+	go s.Serve(l)
+	defer s.Close()
+
+	client, err := telnet.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	time.Sleep(20 * time.Millisecond)
+	client.Write([]byte("x"))
+	select {
+	case d := <-disconnected:
+		if d <= 0 {
+			t.Errorf("Expected positive connection duration, got %v", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected OnDisconnect to be called")
+	}
+	if !connected {
+		t.Error("Expected OnConnect to be called")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []telnet.ConnState{
+		telnet.StateNew, telnet.StateNegotiating, telnet.StateActive,
+		telnet.StateIdle, telnet.StateActive, telnet.StateClosed,
+	}
+	if !reflect.DeepEqual(expected, states) {
+		t.Errorf("Expected states %v, got %v", expected, states)
+	}
+}
+
+func TestServer_ConnStateConcurrentClose(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var states []telnet.ConnState
+	readerDone := make(chan struct{})
+	closed := make(chan struct{})
+	s := telnet.NewServer("", telnet.HandleFunc(func(c *telnet.Connection) {
+		// Read from another goroutine, which is still blocked when the
+		// handler returns and the Server closes the connection.
+		go func() {
+			defer close(readerDone)
+			io.Copy(io.Discard, c)
+		}()
+		time.Sleep(20 * time.Millisecond)
+	}))
+	s.ConnState = func(c *telnet.Connection, state telnet.ConnState) {
+		mu.Lock()
+		states = append(states, state)
+		mu.Unlock()
+		if state == telnet.StateClosed {
+			close(closed)
+		}
+	}
+	go s.Serve(l)
+	defer s.Close()
+
+	client, err := telnet.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	for _, ch := range []chan struct{}{readerDone, closed} {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatal("Expected connection to be closed and reader to finish")
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(states) == 0 || states[len(states)-1] != telnet.StateClosed {
+		t.Errorf("Expected StateClosed to be the last state, got %v", states)
+	}
+	for i, state := range states[:len(states)-1] {
+		if state == telnet.StateClosed {
+			t.Errorf("State %d: StateClosed reported before %v", i, states[i+1:])
+		}
+	}
+}
+
+func TestServer_PanicRecovery(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logBuf bytes.Buffer
+	disconnected := make(chan struct{}, 2)
+	s := telnet.NewServer("", telnet.HandleFunc(func(c *telnet.Connection) {
+		panic("oops")
+	}))
+	s.ErrorLog = log.New(&logBuf, "", 0)
+	s.PanicMessage = "Internal error.\r\n"
+	s.OnDisconnect = func(c *telnet.Connection, d time.Duration) {
+		disconnected <- struct{}{}
+	}
+	go s.Serve(l)
+	defer s.Close()
+
+	// The server keeps serving other connections after a panic.
+	for i := 0; i < 2; i++ {
+		if got := dialAndRead(t, l.Addr().String()); got != "Internal error.\r\n" {
+			t.Errorf("Expected panic message, got %q", got)
+		}
+		<-disconnected
+	}
+	if !strings.Contains(logBuf.String(), "panic serving") || !strings.Contains(logBuf.String(), "oops") {
+		t.Errorf("Expected panic to be disconnected, got %q", logBuf.String())
+	}
+}
+
+func TestServer_PanicInOnConnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var states []telnet.ConnState
+	disconnected := make(chan struct{}, 1)
+	s := telnet.NewServer("", telnet.HandleFunc(func(c *telnet.Connection) {
+		t.Error("Expected handler not to be called")
+	}))
+	s.ErrorLog = log.New(io.Discard, "", 0)
+	s.PanicMessage = "Internal error.\r\n"
+	s.OnConnect = func(c *telnet.Connection) {
+		panic("oops")
+	}
+	s.ConnState = func(c *telnet.Connection, state telnet.ConnState) {
+		mu.Lock()
+		states = append(states, state)
+		mu.Unlock()
+	}
+	s.OnDisconnect = func(c *telnet.Connection, d time.Duration) {
+		disconnected <- struct{}{}
+	}
+	go s.Serve(l)
+	defer s.Close()
+
+	if got := dialAndRead(t, l.Addr().String()); got != "Internal error.\r\n" {
+		t.Errorf("Expected panic message, got %q", got)
+	}
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Fatal("Expected OnDisconnect to be called")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []telnet.ConnState{telnet.StateNew, telnet.StateClosed}
+	if !reflect.DeepEqual(expected, states) {
+		t.Errorf("Expected states %v, got %v", expected, states)
+	}
+}
+
+func TestServer_PanicInOverflowHandler(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	s := telnet.NewServer("", telnet.HandleFunc(func(c *telnet.Connection) {
+		close(started)
+		<-c.Context().Done()
+	}))
+	s.ErrorLog = log.New(io.Discard, "", 0)
+	s.MaxConnections = 1
+	s.OverflowHandler = telnet.HandleFunc(func(c *telnet.Connection) {
+		panic("oops")
+	})
+	go s.Serve(l)
+	defer s.Close()
+
+	client, err := telnet.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	<-started
+	// The refused connection is closed, and the Server survives to refuse
+	// the next one too.
+	for i := 0; i < 2; i++ {
+		if got := dialAndRead(t, l.Addr().String()); got != "" {
+			t.Errorf("Expected connection closed without a message, got %q", got)
+		}
+	}
+}
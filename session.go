@@ -0,0 +1,161 @@
+package telnet
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultBroadcastTimeout is how long Broadcast and Kick wait for each client
+// to accept a message on a Server with no BroadcastTimeout.
+const DefaultBroadcastTimeout = 5 * time.Second
+
+// Session is the Server's record of a live connection. Sessions are
+// registered before options are offered and removed once the handler
+// returns. Metadata may be read and written concurrently from any goroutine;
+// it is stored with the connection's attributes (see Key), so it is also
+// visible to anything holding the Connection.
+type Session struct {
+	// ID uniquely identifies the session within its Server.
+	ID uint64
+	// Conn is the session's connection.
+	Conn *Connection
+	// Started is when the session was registered.
+	Started time.Time
+}
+
+// metaKey is the attribute key under which a Session metadata key is stored.
+type metaKey string
+
+// Get returns the value of the metadata key, if it is set.
+func (s *Session) Get(key string) (string, bool) {
+	v, ok := s.Conn.getAttr(metaKey(key)).(string)
+	return v, ok
+}
+
+// Set sets the metadata key to value.
+func (s *Session) Set(key, value string) {
+	s.Conn.setAttr(metaKey(key), value)
+}
+
+// Delete removes the metadata key.
+func (s *Session) Delete(key string) {
+	s.Conn.deleteAttr(metaKey(key))
+}
+
+// Metadata returns a copy of all of the session's metadata.
+func (s *Session) Metadata() map[string]string {
+	c := s.Conn
+	c.amu.RLock()
+	defer c.amu.RUnlock()
+	meta := make(map[string]string)
+	for k, v := range c.attrs {
+		if k, ok := k.(metaKey); ok {
+			meta[string(k)] = v.(string)
+		}
+	}
+	return meta
+}
+
+// Session returns the Session of a connection handled by the Server, or nil if
+// conn is not live.
+func (s *Server) Session(conn *Connection) *Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns[conn]
+}
+
+// SessionByID returns the live Session with the given ID, if there is one.
+func (s *Server) SessionByID(id uint64) (*Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[id]
+	return sess, ok
+}
+
+// Sessions returns all live Sessions, in the order they were registered. It
+// is a snapshot; sessions may end or begin as soon as it returns.
+func (s *Server) Sessions() []*Session {
+	s.mu.Lock()
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	s.mu.Unlock()
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ID < sessions[j].ID
+	})
+	return sessions
+}
+
+// Broadcast writes msg to every live Session for which filter returns true,
+// or to all of them if filter is nil, and returns how many it was written to.
+// Messages are written concurrently, so Broadcast returns once the slowest
+// client has accepted the message, or after the Server's BroadcastTimeout; a
+// client which takes longer is too slow to keep up, and is disconnected.
+// Deadlines on the connections are left alone, so Broadcast does not disturb
+// handlers' own reads and writes. Broadcast may be used after Stop, for
+// example to warn clients of a Shutdown.
+func (s *Server) Broadcast(msg []byte, filter func(*Session) bool) int {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	sent := 0
+	for _, sess := range s.Sessions() {
+		if filter != nil && !filter(sess) {
+			continue
+		}
+		wg.Add(1)
+		go func(sess *Session) {
+			defer wg.Done()
+			if s.writeWithin(sess.Conn, msg) {
+				mu.Lock()
+				sent++
+				mu.Unlock()
+			}
+		}(sess)
+	}
+	wg.Wait()
+	return sent
+}
+
+// Kick disconnects the Session with the given ID, first writing reason to the
+// client if it is not empty, waiting at most the Server's BroadcastTimeout
+// for it to be accepted. It returns false if there is no such Session.
+func (s *Server) Kick(id uint64, reason string) bool {
+	sess, ok := s.SessionByID(id)
+	if !ok {
+		return false
+	}
+	if reason != "" {
+		s.writeWithin(sess.Conn, []byte(reason))
+	}
+	sess.Conn.Close()
+	return true
+}
+
+// writeWithin writes msg to c, reporting whether it was written within the
+// Server's BroadcastTimeout. The write is serialized with the connection's
+// other writes and does not touch its deadlines, so if it takes too long the
+// connection is closed to interrupt it; otherwise writes to a stalled client
+// would pile up behind one another.
+func (s *Server) writeWithin(c *Connection, msg []byte) bool {
+	d := s.BroadcastTimeout
+	if d == 0 {
+		d = DefaultBroadcastTimeout
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Write(msg)
+		done <- err
+	}()
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case err := <-done:
+		return err == nil
+	case <-t.C:
+		c.Close()
+		<-done
+		return false
+	}
+}
@@ -0,0 +1,206 @@
+package telnet_test
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aprice/telnet"
+)
+
+func TestServer_Sessions(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s *telnet.Server
+	ready := make(chan struct{}, 2)
+	s = telnet.NewServer("", telnet.HandleFunc(func(c *telnet.Connection) {
+		sess := s.Session(c)
+		name, _ := bufio.NewReader(c).ReadString('\n')
+		sess.Set("name", name[:len(name)-1])
+		ready <- struct{}{}
+		<-c.Context().Done()
+	}))
+	go s.Serve(l)
+	defer s.Close()
+
+	var clients []*bufio.Reader
+	for _, name := range []string{"alice", "bob"} {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		c.Write([]byte(name + "\n"))
+		<-ready
+		clients = append(clients, bufio.NewReader(c))
+	}
+
+	sessions := s.Sessions()
+	if len(sessions) != 2 {
+		t.Fatalf("Expected 2 sessions, got %d", len(sessions))
+	}
+	if name, _ := sessions[0].Get("name"); name != "alice" {
+		t.Errorf("Expected first session to be alice, got %q", name)
+	}
+	if sessions[0].ID >= sessions[1].ID {
+		t.Errorf("Expected sessions in order, got IDs %d, %d", sessions[0].ID, sessions[1].ID)
+	}
+
+	n := s.Broadcast([]byte("hi bob\n"), func(sess *telnet.Session) bool {
+		name, _ := sess.Get("name")
+		return name == "bob"
+	})
+	if n != 1 {
+		t.Errorf("Expected broadcast to 1 session, got %d", n)
+	}
+	if n := s.Broadcast([]byte("hi all\n"), nil); n != 2 {
+		t.Errorf("Expected broadcast to 2 sessions, got %d", n)
+	}
+	if line, _ := clients[0].ReadString('\n'); line != "hi all\n" {
+		t.Errorf("Expected alice to get only the broadcast to all, got %q", line)
+	}
+	if line, _ := clients[1].ReadString('\n'); line != "hi bob\n" {
+		t.Errorf("Expected bob to get his message, got %q", line)
+	}
+
+	if !s.Kick(sessions[1].ID, "bye\n") {
+		t.Error("Expected Kick to find bob")
+	}
+	clients[1].ReadString('\n') // "hi all"
+	if line, _ := clients[1].ReadString('\n'); line != "bye\n" {
+		t.Errorf("Expected kick reason, got %q", line)
+	}
+	if _, err := clients[1].ReadByte(); err == nil {
+		t.Error("Expected kicked connection to be closed")
+	}
+	if s.Kick(12345, "") {
+		t.Error("Expected Kick of unknown session to fail")
+	}
+	if _, ok := s.SessionByID(sessions[0].ID); !ok {
+		t.Error("Expected alice's session to still be live")
+	}
+}
+
+func TestServer_BroadcastKeepsDeadlines(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ready := make(chan struct{})
+	broadcast := make(chan struct{})
+	result := make(chan error, 1)
+	s := telnet.NewServer("", telnet.HandleFunc(func(c *telnet.Connection) {
+		c.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+		close(ready)
+		<-broadcast
+		time.Sleep(150 * time.Millisecond)
+		// The handler's deadline must still be in force.
+		_, err := c.Write([]byte("late"))
+		result <- err
+	}))
+	go s.Serve(l)
+	defer s.Close()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	go io.Copy(io.Discard, client)
+	<-ready
+	if n := s.Broadcast([]byte("hello"), nil); n != 1 {
+		t.Errorf("Expected broadcast to 1 session, got %d", n)
+	}
+	close(broadcast)
+	select {
+	case err := <-result:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("Expected handler's write deadline to be kept, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected handler to finish")
+	}
+}
+
+// pipeListener accepts the server ends of net.Pipe connections, whose writes
+// block until the client reads.
+type pipeListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+func (l *pipeListener) Dial() net.Conn {
+	client, server := net.Pipe()
+	l.conns <- server
+	return client
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: "pipe", Net: "unix"}
+}
+
+func TestServer_BroadcastSlowClient(t *testing.T) {
+	l := newPipeListener()
+	ready := make(chan struct{}, 2)
+	s := telnet.NewServer("", telnet.HandleFunc(func(c *telnet.Connection) {
+		ready <- struct{}{}
+		<-c.Context().Done()
+	}))
+	s.BroadcastTimeout = 50 * time.Millisecond
+	go s.Serve(l)
+	defer s.Close()
+
+	fast := l.Dial()
+	defer fast.Close()
+	go io.Copy(io.Discard, fast)
+	slow := l.Dial() // never read
+	defer slow.Close()
+	<-ready
+	<-ready
+
+	start := time.Now()
+	if n := s.Broadcast([]byte("hello"), nil); n != 1 {
+		t.Errorf("Expected broadcast to 1 session, got %d", n)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Expected Broadcast to give up on the slow client, took %v", d)
+	}
+	// The slow client is disconnected rather than left with a write pending.
+	deadline := time.Now().Add(time.Second)
+	for len(s.Sessions()) != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := len(s.Sessions()); n != 1 {
+		t.Errorf("Expected slow session to be closed, %d sessions remain", n)
+	}
+	if n := s.Broadcast([]byte("again"), nil); n != 1 {
+		t.Errorf("Expected second broadcast to 1 session, got %d", n)
+	}
+}
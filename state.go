@@ -0,0 +1,62 @@
+package telnet
+
+import "strconv"
+
+// ConnState represents the state of a connection to a Server, as reported to
+// the Server's ConnState hook.
+type ConnState int
+
+const (
+	// StateNew is a connection which has just been accepted, before any
+	// options have been offered.
+	StateNew ConnState = iota
+	// StateNegotiating is a connection whose options are being offered.
+	StateNegotiating
+	// StateActive is a connection whose handler is running and which is not
+	// waiting for data from the client.
+	StateActive
+	// StateIdle is a connection whose handler is blocked reading, waiting for
+	// data from the client. It returns to StateActive once data arrives.
+	StateIdle
+	// StateClosed is a connection whose handler has returned and which has
+	// been closed. This is a terminal state.
+	StateClosed
+)
+
+var stateNames = map[ConnState]string{
+	StateNew:         "new",
+	StateNegotiating: "negotiating",
+	StateActive:      "active",
+	StateIdle:        "idle",
+	StateClosed:      "closed",
+}
+
+func (s ConnState) String() string {
+	if name, ok := stateNames[s]; ok {
+		return name
+	}
+	return "ConnState(" + strconv.Itoa(int(s)) + ")"
+}
+
+// setState moves the connection to state s, reporting the change to the
+// connection's state hook, if any. Once closed, a connection stays closed.
+// Changes may come from the handler and from whichever goroutine is reading,
+// so they are serialized, and each is reported before the next is made.
+func (c *Connection) setState(s ConnState) {
+	c.smu.Lock()
+	defer c.smu.Unlock()
+	if c.state == s || c.state == StateClosed {
+		return
+	}
+	c.state = s
+	if c.stateHook != nil {
+		c.stateHook(c, s)
+	}
+}
+
+// getState returns the connection's current state.
+func (c *Connection) getState() ConnState {
+	c.smu.Lock()
+	defer c.smu.Unlock()
+	return c.state
+}
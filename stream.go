@@ -0,0 +1,66 @@
+package telnet
+
+import (
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// NewStreamConnection initializes a new Connection over any byte stream, such
+// as a pipe, WebSocket, SSH channel or serial port, in the same way as
+// NewConnection. If rwc is a net.Conn it is used as is; otherwise it is
+// adapted to one, reporting placeholder addresses and delegating deadlines to
+// rwc if it supports them.
+func NewStreamConnection(rwc io.ReadWriteCloser, options []Option) *Connection {
+	if c, ok := rwc.(net.Conn); ok {
+		return NewConnection(c, options)
+	}
+	return NewConnection(&streamConn{rwc}, options)
+}
+
+// streamAddr is the placeholder address of a stream which is not a net.Conn.
+type streamAddr struct{}
+
+func (streamAddr) Network() string { return "stream" }
+func (streamAddr) String() string  { return "stream" }
+
+// streamConn adapts an io.ReadWriteCloser to net.Conn.
+type streamConn struct {
+	io.ReadWriteCloser
+}
+
+func (s *streamConn) LocalAddr() net.Addr {
+	return streamAddr{}
+}
+
+func (s *streamConn) RemoteAddr() net.Addr {
+	return streamAddr{}
+}
+
+// SetDeadline delegates to the stream if it supports deadlines, as *os.File
+// does; otherwise it returns os.ErrNoDeadline.
+func (s *streamConn) SetDeadline(t time.Time) error {
+	if d, ok := s.ReadWriteCloser.(interface{ SetDeadline(time.Time) error }); ok {
+		return d.SetDeadline(t)
+	}
+	return os.ErrNoDeadline
+}
+
+// SetReadDeadline delegates to the stream if it supports read deadlines;
+// otherwise it returns os.ErrNoDeadline.
+func (s *streamConn) SetReadDeadline(t time.Time) error {
+	if d, ok := s.ReadWriteCloser.(interface{ SetReadDeadline(time.Time) error }); ok {
+		return d.SetReadDeadline(t)
+	}
+	return os.ErrNoDeadline
+}
+
+// SetWriteDeadline delegates to the stream if it supports write deadlines;
+// otherwise it returns os.ErrNoDeadline.
+func (s *streamConn) SetWriteDeadline(t time.Time) error {
+	if d, ok := s.ReadWriteCloser.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		return d.SetWriteDeadline(t)
+	}
+	return os.ErrNoDeadline
+}
@@ -0,0 +1,73 @@
+package telnet_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aprice/telnet"
+)
+
+// pipeStream joins the two halves of a pair of io.Pipes into a single stream.
+type pipeStream struct {
+	*io.PipeReader
+	*io.PipeWriter
+}
+
+func (p pipeStream) Close() error {
+	p.PipeReader.Close()
+	return p.PipeWriter.Close()
+}
+
+func TestNewStreamConnection(t *testing.T) {
+	sr, cw := io.Pipe()
+	cr, sw := io.Pipe()
+	go func() {
+		b := make([]byte, 3)
+		io.ReadFull(cr, b)
+		if !bytes.Equal(b, []byte{255, 253, 31}) {
+			t.Errorf("Expected IAC DO NAWS, received %v", b)
+		}
+		// IAC WILL NAWS IAC SB NAWS W[1] W[0] H[1] H[0] IAC SE
+		cw.Write([]byte{255, 251, 31, 255, 250, 31, 0, 80, 0, 24, 255, 240, 'h', 'i'})
+		cw.Close()
+	}()
+	conn := telnet.NewStreamConnection(pipeStream{sr, sw}, []telnet.Option{telnet.NAWSOption})
+	b, err := io.ReadAll(conn)
+	if err != nil {
+		t.Error(err)
+	}
+	if string(b) != "hi" {
+		t.Errorf("Expected %q, got %q", "hi", b)
+	}
+	nw := conn.OptionHandlers[telnet.NAWS].(*telnet.NAWSHandler)
+	if nw.Width != 80 || nw.Height != 24 {
+		t.Errorf("Expected w %d, h %d, got w %d, h %d", 80, 24, nw.Width, nw.Height)
+	}
+	if conn.RemoteAddr().Network() != "stream" {
+		t.Errorf("Expected stream address, got %v", conn.RemoteAddr())
+	}
+	if err := conn.SetReadDeadline(time.Now()); err != os.ErrNoDeadline {
+		t.Errorf("Expected ErrNoDeadline, got %v", err)
+	}
+	conn.Close()
+}
+
+func TestNewStreamConnection_Deadlines(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	conn := telnet.NewStreamConnection(r, nil)
+	defer conn.Close()
+	if err := conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Read(make([]byte, 8))
+	if !os.IsTimeout(err) {
+		t.Errorf("Expected timeout, got %v", err)
+	}
+}
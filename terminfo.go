@@ -0,0 +1,188 @@
+package telnet
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TSPEEDOption enables TERMINAL-SPEED (RFC 1079) negotiation on a Server.
+func TSPEEDOption(c *Connection) Negotiator {
+	return &TSPEEDHandler{client: false}
+}
+
+// ExposeTSPEED enables TERMINAL-SPEED negotiation on a Client, reporting the
+// given transmit and receive speeds in bits per second.
+func ExposeTSPEED(transmit, receive uint32) Option {
+	return func(c *Connection) Negotiator {
+		return &TSPEEDHandler{Transmit: transmit, Receive: receive, client: true}
+	}
+}
+
+// TSPEEDHandler negotiates TERMINAL-SPEED for a specific connection.
+type TSPEEDHandler struct {
+	Transmit uint32
+	Receive  uint32
+
+	client bool
+}
+
+func (t *TSPEEDHandler) OptionCode() byte {
+	return TSPEED
+}
+
+func (t *TSPEEDHandler) Offer(c *Connection) {
+	if !t.client {
+		c.WriteCommand(DO, t.OptionCode())
+	}
+}
+
+func (t *TSPEEDHandler) HandleWill(c *Connection) {
+	if !t.client {
+		c.WriteSubnegotiation(t.OptionCode(), []byte{SEND})
+		c.AwaitSubnegotiation(t.OptionCode())
+	}
+}
+
+func (t *TSPEEDHandler) HandleDo(c *Connection) {
+	if t.client {
+		c.WriteCommand(WILL, t.OptionCode())
+	} else {
+		c.WriteCommand(WONT, t.OptionCode())
+	}
+}
+
+func (t *TSPEEDHandler) HandleSB(c *Connection, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	if t.client && b[0] == SEND {
+		value := fmt.Sprintf("%d,%d", t.Transmit, t.Receive)
+		c.WriteSubnegotiation(t.OptionCode(), append([]byte{IS}, value...))
+	} else if !t.client && b[0] == IS {
+		speeds := strings.SplitN(string(b[1:]), ",", 2)
+		if len(speeds) != 2 {
+			return
+		}
+		transmit, err := strconv.ParseUint(strings.TrimSpace(speeds[0]), 10, 32)
+		if err != nil {
+			return
+		}
+		receive, err := strconv.ParseUint(strings.TrimSpace(speeds[1]), 10, 32)
+		if err != nil {
+			return
+		}
+		t.Transmit = uint32(transmit)
+		t.Receive = uint32(receive)
+	}
+}
+
+// XDISPLOCOption enables X-DISPLAY-LOCATION (RFC 1096) negotiation on a
+// Server.
+func XDISPLOCOption(c *Connection) Negotiator {
+	return &XDISPLOCHandler{client: false}
+}
+
+// ExposeXDISPLOC enables X-DISPLAY-LOCATION negotiation on a Client,
+// reporting the given X display, in host:display[.screen] format.
+func ExposeXDISPLOC(display string) Option {
+	return func(c *Connection) Negotiator {
+		return &XDISPLOCHandler{Display: display, client: true}
+	}
+}
+
+// XDISPLOCHandler negotiates X-DISPLAY-LOCATION for a specific connection.
+type XDISPLOCHandler struct {
+	Display string
+
+	client bool
+}
+
+func (x *XDISPLOCHandler) OptionCode() byte {
+	return XDISPLOC
+}
+
+func (x *XDISPLOCHandler) Offer(c *Connection) {
+	if !x.client {
+		c.WriteCommand(DO, x.OptionCode())
+	}
+}
+
+func (x *XDISPLOCHandler) HandleWill(c *Connection) {
+	if !x.client {
+		c.WriteSubnegotiation(x.OptionCode(), []byte{SEND})
+		c.AwaitSubnegotiation(x.OptionCode())
+	}
+}
+
+func (x *XDISPLOCHandler) HandleDo(c *Connection) {
+	if x.client {
+		c.WriteCommand(WILL, x.OptionCode())
+	} else {
+		c.WriteCommand(WONT, x.OptionCode())
+	}
+}
+
+func (x *XDISPLOCHandler) HandleSB(c *Connection, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	if x.client && b[0] == SEND {
+		c.WriteSubnegotiation(x.OptionCode(), append([]byte{IS}, x.Display...))
+	} else if !x.client && b[0] == IS {
+		x.Display = string(b[1:])
+	}
+}
+
+// SNDLOCOption enables SEND-LOCATION (RFC 779) negotiation on a Server.
+func SNDLOCOption(c *Connection) Negotiator {
+	return &SNDLOCHandler{client: false}
+}
+
+// ExposeSNDLOC enables SEND-LOCATION negotiation on a Client, reporting the
+// given free-form location.
+func ExposeSNDLOC(location string) Option {
+	return func(c *Connection) Negotiator {
+		return &SNDLOCHandler{Location: location, client: true}
+	}
+}
+
+// SNDLOCHandler negotiates SEND-LOCATION for a specific connection. Unlike
+// TSPEED and XDISPLOC, the location is sent unprompted once the option is
+// agreed, with no IS/SEND exchange.
+type SNDLOCHandler struct {
+	Location string
+
+	client bool
+}
+
+func (s *SNDLOCHandler) OptionCode() byte {
+	return SNDLOC
+}
+
+func (s *SNDLOCHandler) Offer(c *Connection) {
+	if !s.client {
+		c.WriteCommand(DO, s.OptionCode())
+	}
+}
+
+func (s *SNDLOCHandler) HandleWill(c *Connection) {
+	if !s.client {
+		c.AwaitSubnegotiation(s.OptionCode())
+	}
+}
+
+func (s *SNDLOCHandler) HandleDo(c *Connection) {
+	if s.client {
+		c.WriteCommand(WILL, s.OptionCode())
+		c.WriteSubnegotiation(s.OptionCode(), []byte(s.Location))
+	} else {
+		c.WriteCommand(WONT, s.OptionCode())
+	}
+}
+
+func (s *SNDLOCHandler) HandleSB(c *Connection, b []byte) {
+	if !s.client {
+		s.Location = string(b)
+	}
+}
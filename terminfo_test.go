@@ -0,0 +1,98 @@
+package telnet_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/aprice/telnet"
+)
+
+func TestServerTSPEED(t *testing.T) {
+	text := []byte("hello\n")
+	// IAC WILL TSPEED IAC SB TSPEED IS "38400,19200" IAC SE
+	sc := newScriptConn([]byte{255, 251, 32, 255, 250, 32, 0}, []byte("38400,19200"), []byte{255, 240}, text)
+	conn := telnet.NewConnection(sc, []telnet.Option{telnet.TSPEEDOption})
+	b := make([]byte, 32)
+	n, err := conn.Read(b)
+	if err != nil {
+		t.Error(err)
+	}
+	if !bytes.Equal(text, b[:n]) {
+		t.Errorf("Expected %q, got %q", text, b[:n])
+	}
+	// IAC DO TSPEED IAC SB TSPEED SEND IAC SE
+	expected := []byte{255, 253, 32, 255, 250, 32, 1, 255, 240}
+	if !bytes.Equal(expected, sc.out.Bytes()) {
+		t.Errorf("Expected %v, received %v", expected, sc.out.Bytes())
+	}
+	ts := conn.OptionHandlers[telnet.TSPEED].(*telnet.TSPEEDHandler)
+	if ts.Transmit != 38400 || ts.Receive != 19200 {
+		t.Errorf("Expected tx %d, rx %d, got tx %d, rx %d", 38400, 19200, ts.Transmit, ts.Receive)
+	}
+}
+
+func TestClientTSPEED(t *testing.T) {
+	// IAC DO TSPEED IAC SB TSPEED SEND IAC SE
+	sc := newScriptConn([]byte{255, 253, 32, 255, 250, 32, 1, 255, 240})
+	conn := telnet.NewConnection(sc, []telnet.Option{telnet.ExposeTSPEED(9600, 4800)})
+	conn.Read(make([]byte, 32))
+	// IAC WILL TSPEED IAC SB TSPEED IS "9600,4800" IAC SE
+	expected := append([]byte{255, 251, 32, 255, 250, 32, 0}, "9600,4800"...)
+	expected = append(expected, 255, 240)
+	if !bytes.Equal(expected, sc.out.Bytes()) {
+		t.Errorf("Expected %v, received %v", expected, sc.out.Bytes())
+	}
+}
+
+func TestServerXDISPLOC(t *testing.T) {
+	// IAC WILL XDISPLOC IAC SB XDISPLOC IS "host:0.0" IAC SE
+	sc := newScriptConn([]byte{255, 251, 35, 255, 250, 35, 0}, []byte("host:0.0"), []byte{255, 240, 'x'})
+	conn := telnet.NewConnection(sc, []telnet.Option{telnet.XDISPLOCOption})
+	conn.Read(make([]byte, 32))
+	// IAC DO XDISPLOC IAC SB XDISPLOC SEND IAC SE
+	expected := []byte{255, 253, 35, 255, 250, 35, 1, 255, 240}
+	if !bytes.Equal(expected, sc.out.Bytes()) {
+		t.Errorf("Expected %v, received %v", expected, sc.out.Bytes())
+	}
+	xd := conn.OptionHandlers[telnet.XDISPLOC].(*telnet.XDISPLOCHandler)
+	if xd.Display != "host:0.0" {
+		t.Errorf("Expected display %q, got %q", "host:0.0", xd.Display)
+	}
+}
+
+func TestClientXDISPLOC(t *testing.T) {
+	// IAC DO XDISPLOC IAC SB XDISPLOC SEND IAC SE
+	sc := newScriptConn([]byte{255, 253, 35, 255, 250, 35, 1, 255, 240})
+	conn := telnet.NewConnection(sc, []telnet.Option{telnet.ExposeXDISPLOC("host:1")})
+	conn.Read(make([]byte, 32))
+	// IAC WILL XDISPLOC IAC SB XDISPLOC IS "host:1" IAC SE
+	expected := append([]byte{255, 251, 35, 255, 250, 35, 0}, "host:1"...)
+	expected = append(expected, 255, 240)
+	if !bytes.Equal(expected, sc.out.Bytes()) {
+		t.Errorf("Expected %v, received %v", expected, sc.out.Bytes())
+	}
+}
+
+func TestSNDLOC(t *testing.T) {
+	// IAC DO SNDLOC
+	client := newScriptConn([]byte{255, 253, 23})
+	conn := telnet.NewConnection(client, []telnet.Option{telnet.ExposeSNDLOC("Room 101")})
+	conn.Read(make([]byte, 32))
+	// IAC WILL SNDLOC IAC SB SNDLOC "Room 101" IAC SE
+	expected := append([]byte{255, 251, 23, 255, 250, 23}, "Room 101"...)
+	expected = append(expected, 255, 240)
+	if !bytes.Equal(expected, client.out.Bytes()) {
+		t.Fatalf("Expected %v, received %v", expected, client.out.Bytes())
+	}
+
+	server := newScriptConn(client.out.Bytes())
+	conn = telnet.NewConnection(server, []telnet.Option{telnet.SNDLOCOption})
+	conn.Read(make([]byte, 32))
+	if !bytes.Equal([]byte{255, 253, 23}, server.out.Bytes()) {
+		t.Errorf("Expected IAC DO SNDLOC, received %v", server.out.Bytes())
+	}
+	sl := conn.OptionHandlers[telnet.SNDLOC].(*telnet.SNDLOCHandler)
+	if sl.Location != "Room 101" {
+		t.Errorf("Expected location %q, got %q", "Room 101", sl.Location)
+	}
+}
@@ -0,0 +1,323 @@
+package telnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// TN3270E subnegotiation commands, per RFC 2355.
+const (
+	TN3270EAssociate  = byte(0)
+	TN3270EConnect    = byte(1)
+	TN3270EDeviceType = byte(2)
+	TN3270EFunctions  = byte(3)
+	TN3270EIs         = byte(4)
+	TN3270EReason     = byte(5)
+	TN3270EReject     = byte(6)
+	TN3270ERequest    = byte(7)
+	TN3270ESend       = byte(8)
+)
+
+// TN3270E reason codes, sent with a DEVICE-TYPE REJECT.
+const (
+	TN3270EConnPartner    = byte(0)
+	TN3270EDeviceInUse    = byte(1)
+	TN3270EInvAssociate   = byte(2)
+	TN3270EInvName        = byte(3)
+	TN3270EInvDeviceType  = byte(4)
+	TN3270ETypeNameError  = byte(5)
+	TN3270EUnknownError   = byte(6)
+	TN3270EUnsupportedReq = byte(7)
+)
+
+// TN3270E functions, negotiated with FUNCTIONS REQUEST/IS.
+const (
+	TN3270EBindImage     = byte(0)
+	TN3270EDataStreamCtl = byte(1)
+	TN3270EResponses     = byte(2)
+	TN3270ESCSCtlCodes   = byte(3)
+	TN3270ESysreq        = byte(4)
+)
+
+// TN3270E data types, sent in the DataType field of a TN3270EHeader.
+const (
+	TN3270EType3270Data   = byte(0x00)
+	TN3270ETypeSCSData    = byte(0x01)
+	TN3270ETypeResponse   = byte(0x02)
+	TN3270ETypeBindImage  = byte(0x03)
+	TN3270ETypeUnbind     = byte(0x04)
+	TN3270ETypeNVTData    = byte(0x05)
+	TN3270ETypeRequest    = byte(0x06)
+	TN3270ETypeSSCPLUData = byte(0x07)
+	TN3270ETypePrintEOJ   = byte(0x08)
+)
+
+// TN3270E response flags, sent in the ResponseFlag field of a TN3270EHeader.
+// For messages of type TN3270ETypeResponse, TN3270EPositiveResponse and
+// TN3270ENegativeResponse are used instead.
+const (
+	TN3270ENoResponse       = byte(0x00)
+	TN3270EErrorResponse    = byte(0x01)
+	TN3270EAlwaysResponse   = byte(0x02)
+	TN3270EPositiveResponse = byte(0x00)
+	TN3270ENegativeResponse = byte(0x01)
+)
+
+// TN3270EHeaderLen is the length of the header preceding the data in every
+// TN3270E record.
+const TN3270EHeaderLen = 5
+
+// ErrShortRecord is returned when a TN3270E record is too short to contain a
+// header.
+var ErrShortRecord = errors.New("telnet: TN3270E record shorter than header")
+
+// TN3270ERejectError is reported by a client's TN3270EHandler when the server
+// rejects the requested device type or name.
+type TN3270ERejectError struct {
+	Reason byte
+}
+
+var tn3270eReasons = map[byte]string{
+	TN3270EConnPartner:    "CONN-PARTNER",
+	TN3270EDeviceInUse:    "DEVICE-IN-USE",
+	TN3270EInvAssociate:   "INV-ASSOCIATE",
+	TN3270EInvName:        "INV-NAME",
+	TN3270EInvDeviceType:  "INV-DEVICE-TYPE",
+	TN3270ETypeNameError:  "TYPE-NAME-ERROR",
+	TN3270EUnknownError:   "UNKNOWN-ERROR",
+	TN3270EUnsupportedReq: "UNSUPPORTED-REQ",
+}
+
+func (e *TN3270ERejectError) Error() string {
+	if name, ok := tn3270eReasons[e.Reason]; ok {
+		return "telnet: TN3270E device rejected: " + name
+	}
+	return fmt.Sprintf("telnet: TN3270E device rejected: reason %d", e.Reason)
+}
+
+// TN3270EHeader is the header preceding the data in every TN3270E record.
+type TN3270EHeader struct {
+	DataType     byte
+	RequestFlag  byte
+	ResponseFlag byte
+	SeqNumber    uint16
+}
+
+// TN3270EOption enables TN3270E (RFC 2355) negotiation on a Server, agreeing
+// to any of the given functions the client requests. Clients are assigned the
+// device name they ask to CONNECT to, or a generated one if they don't ask;
+// ASSOCIATE requests are rejected.
+func TN3270EOption(functions ...byte) Option {
+	return func(c *Connection) Negotiator {
+		return newTN3270EHandler(c, false, functions)
+	}
+}
+
+// ExposeTN3270E enables TN3270E negotiation on a Client, requesting the given
+// device type (e.g. "IBM-3278-2-E") and functions. If deviceName is not empty,
+// the client asks to CONNECT to that specific device.
+func ExposeTN3270E(deviceType, deviceName string, functions ...byte) Option {
+	return func(c *Connection) Negotiator {
+		h := newTN3270EHandler(c, true, functions)
+		h.DeviceType = deviceType
+		h.DeviceName = deviceName
+		return h
+	}
+}
+
+var tn3270eDevices uint32
+
+// TN3270EHandler negotiates TN3270E for a specific connection, and frames
+// 3270 data streams in TN3270E records once negotiation is complete.
+//
+// Negotiation happens as the connection is read, so a client will typically
+// just begin calling ReadMessage; Ready may be used to find out when the
+// device type and functions have been settled.
+type TN3270EHandler struct {
+	// DeviceType is the terminal model requested by the client.
+	DeviceType string
+	// DeviceName is the name of the device (LU) assigned by the server.
+	DeviceName string
+	// Functions are the functions agreed by both ends.
+	Functions []byte
+
+	conn      *Connection
+	client    bool
+	supported []byte
+
+	ready     chan struct{}
+	readyOnce sync.Once
+	err       error
+}
+
+func newTN3270EHandler(c *Connection, client bool, functions []byte) *TN3270EHandler {
+	return &TN3270EHandler{
+		conn:      c,
+		client:    client,
+		supported: functions,
+		ready:     make(chan struct{}),
+	}
+}
+
+func (t *TN3270EHandler) OptionCode() byte {
+	return TN3270E
+}
+
+func (t *TN3270EHandler) Offer(c *Connection) {
+	if !t.client {
+		c.WriteCommand(DO, t.OptionCode())
+	}
+}
+
+func (t *TN3270EHandler) HandleWill(c *Connection) {
+	if !t.client {
+		c.WriteSubnegotiation(t.OptionCode(), []byte{TN3270ESend, TN3270EDeviceType})
+		c.AwaitSubnegotiation(t.OptionCode())
+	}
+}
+
+func (t *TN3270EHandler) HandleDo(c *Connection) {
+	if t.client {
+		c.WriteCommand(WILL, t.OptionCode())
+	} else {
+		c.WriteCommand(WONT, t.OptionCode())
+	}
+}
+
+func (t *TN3270EHandler) HandleSB(c *Connection, b []byte) {
+	if len(b) < 2 {
+		return
+	}
+	switch {
+	case b[0] == TN3270ESend && b[1] == TN3270EDeviceType && t.client:
+		body := append([]byte{TN3270EDeviceType, TN3270ERequest}, t.DeviceType...)
+		if t.DeviceName != "" {
+			body = append(body, TN3270EConnect)
+			body = append(body, t.DeviceName...)
+		}
+		c.WriteSubnegotiation(t.OptionCode(), body)
+	case b[0] == TN3270EDeviceType && b[1] == TN3270ERequest && !t.client:
+		t.handleDeviceRequest(c, b[2:])
+	case b[0] == TN3270EDeviceType && b[1] == TN3270EIs && t.client:
+		deviceType, name, _ := splitTN3270EName(b[2:])
+		t.DeviceType = deviceType
+		t.DeviceName = name
+		c.WriteSubnegotiation(t.OptionCode(), append([]byte{TN3270EFunctions, TN3270ERequest}, t.supported...))
+	case b[0] == TN3270EDeviceType && b[1] == TN3270EReject && t.client:
+		reason := TN3270EUnknownError
+		if len(b) > 3 && b[2] == TN3270EReason {
+			reason = b[3]
+		}
+		c.WriteCommand(WONT, t.OptionCode())
+		t.finish(&TN3270ERejectError{Reason: reason})
+	case b[0] == TN3270EFunctions && b[1] == TN3270ERequest:
+		requested := b[2:]
+		agreed := t.agree(requested)
+		if bytes.Equal(agreed, requested) {
+			c.WriteSubnegotiation(t.OptionCode(), append([]byte{TN3270EFunctions, TN3270EIs}, agreed...))
+			t.Functions = agreed
+			t.finish(nil)
+		} else {
+			c.WriteSubnegotiation(t.OptionCode(), append([]byte{TN3270EFunctions, TN3270ERequest}, agreed...))
+			c.AwaitSubnegotiation(t.OptionCode())
+		}
+	case b[0] == TN3270EFunctions && b[1] == TN3270EIs:
+		t.Functions = append([]byte(nil), b[2:]...)
+		t.finish(nil)
+	}
+}
+
+func (t *TN3270EHandler) handleDeviceRequest(c *Connection, b []byte) {
+	deviceType, name, kind := splitTN3270EName(b)
+	if deviceType == "" {
+		c.WriteSubnegotiation(t.OptionCode(), []byte{TN3270EDeviceType, TN3270EReject, TN3270EReason, TN3270EInvDeviceType})
+		return
+	}
+	if kind == TN3270EAssociate {
+		c.WriteSubnegotiation(t.OptionCode(), []byte{TN3270EDeviceType, TN3270EReject, TN3270EReason, TN3270EUnsupportedReq})
+		return
+	}
+	if name == "" {
+		name = fmt.Sprintf("TN%06d", atomic.AddUint32(&tn3270eDevices, 1))
+	}
+	t.DeviceType = deviceType
+	t.DeviceName = name
+	body := append([]byte{TN3270EDeviceType, TN3270EIs}, deviceType...)
+	body = append(body, TN3270EConnect)
+	body = append(body, name...)
+	c.WriteSubnegotiation(t.OptionCode(), body)
+	c.AwaitSubnegotiation(t.OptionCode())
+}
+
+// splitTN3270EName splits `<device-type> [CONNECT|ASSOCIATE <name>]`.
+func splitTN3270EName(b []byte) (deviceType, name string, kind byte) {
+	i := bytes.IndexAny(b, string([]byte{TN3270EAssociate, TN3270EConnect}))
+	if i < 0 {
+		return string(b), "", TN3270EConnect
+	}
+	return string(b[:i]), string(b[i+1:]), b[i]
+}
+
+// agree returns the requested functions which this end supports.
+func (t *TN3270EHandler) agree(requested []byte) []byte {
+	agreed := make([]byte, 0, len(requested))
+	for _, f := range requested {
+		if bytes.IndexByte(t.supported, f) >= 0 {
+			agreed = append(agreed, f)
+		}
+	}
+	return agreed
+}
+
+func (t *TN3270EHandler) finish(err error) {
+	t.readyOnce.Do(func() {
+		t.err = err
+		close(t.ready)
+	})
+}
+
+// Ready returns a channel which is closed once the device type and functions
+// have been negotiated, or the device has been rejected; see Err.
+func (t *TN3270EHandler) Ready() <-chan struct{} {
+	return t.ready
+}
+
+// Err returns the reason negotiation failed, if it did. It should only be
+// called after Ready is closed.
+func (t *TN3270EHandler) Err() error {
+	return t.err
+}
+
+// ReadMessage reads the next TN3270E record from the connection, returning
+// its header and data.
+func (t *TN3270EHandler) ReadMessage() (TN3270EHeader, []byte, error) {
+	var h TN3270EHeader
+	rec, err := t.conn.ReadRecord()
+	if err != nil {
+		return h, nil, err
+	}
+	if len(rec) < TN3270EHeaderLen {
+		return h, nil, ErrShortRecord
+	}
+	h.DataType = rec[0]
+	h.RequestFlag = rec[1]
+	h.ResponseFlag = rec[2]
+	h.SeqNumber = binary.BigEndian.Uint16(rec[3:5])
+	return h, rec[TN3270EHeaderLen:], nil
+}
+
+// WriteMessage writes data to the connection as a single TN3270E record with
+// the given header.
+func (t *TN3270EHandler) WriteMessage(h TN3270EHeader, data []byte) error {
+	rec := make([]byte, TN3270EHeaderLen, TN3270EHeaderLen+len(data))
+	rec[0] = h.DataType
+	rec[1] = h.RequestFlag
+	rec[2] = h.ResponseFlag
+	binary.BigEndian.PutUint16(rec[3:5], h.SeqNumber)
+	_, err := t.conn.WriteRecord(append(rec, data...))
+	return err
+}
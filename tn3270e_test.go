@@ -0,0 +1,140 @@
+package telnet_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/aprice/telnet"
+)
+
+func sb3270(body ...byte) []byte {
+	return append(append([]byte{255, 250, 40}, body...), 255, 240)
+}
+
+func TestClientTN3270E(t *testing.T) {
+	data := []byte{0xf5, 0xc3, 0xff, 0x11}
+	sc := newScriptConn(
+		[]byte{255, 253, 40}, // IAC DO TN3270E
+		sb3270(8, 2),         // SEND DEVICE-TYPE
+		sb3270(append(append([]byte{2, 4}, "IBM-3278-2-E"...), append([]byte{1}, "LU01"...)...)...), // DEVICE-TYPE IS <type> CONNECT <name>
+		sb3270(3, 4, 2), // FUNCTIONS IS RESPONSES
+		[]byte{0, 0, 2, 0, 7, 0xf5, 0xc3, 0xff, 0xff, 0x11, 255, 239}, // record
+	)
+	conn := telnet.NewConnection(sc, []telnet.Option{telnet.ExposeTN3270E("IBM-3278-2-E", "", telnet.TN3270EBindImage, telnet.TN3270EResponses)})
+	tn := conn.OptionHandlers[telnet.TN3270E].(*telnet.TN3270EHandler)
+	h, b, err := tn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedHeader := telnet.TN3270EHeader{DataType: telnet.TN3270EType3270Data, ResponseFlag: telnet.TN3270EAlwaysResponse, SeqNumber: 7}
+	if h != expectedHeader {
+		t.Errorf("Expected header %+v, got %+v", expectedHeader, h)
+	}
+	if !bytes.Equal(data, b) {
+		t.Errorf("Expected data %v, got %v", data, b)
+	}
+	select {
+	case <-tn.Ready():
+	default:
+		t.Fatal("Expected negotiation to be complete")
+	}
+	if tn.Err() != nil || tn.DeviceName != "LU01" || !bytes.Equal(tn.Functions, []byte{telnet.TN3270EResponses}) {
+		t.Errorf("Unexpected negotiation result: %v %q %v", tn.Err(), tn.DeviceName, tn.Functions)
+	}
+
+	expected := []byte{255, 251, 40}
+	expected = append(expected, sb3270(append([]byte{2, 7}, "IBM-3278-2-E"...)...)...)
+	expected = append(expected, sb3270(3, 7, 0, 2)...)
+	if !bytes.Equal(expected, sc.out.Bytes()) {
+		t.Errorf("Expected %v, received %v", expected, sc.out.Bytes())
+	}
+}
+
+func TestServerTN3270E(t *testing.T) {
+	sc := newScriptConn(
+		[]byte{255, 251, 40}, // IAC WILL TN3270E
+		sb3270(append(append([]byte{2, 7}, "IBM-3279-2-E"...), append([]byte{1}, "LU02"...)...)...), // DEVICE-TYPE REQUEST <type> CONNECT <name>
+		sb3270(3, 7, 0, 2), // FUNCTIONS REQUEST BIND-IMAGE RESPONSES
+		sb3270(3, 4, 2),    // FUNCTIONS IS RESPONSES
+		[]byte{0, 0, 0, 0, 1, 0x7d, 255, 239},
+	)
+	conn := telnet.NewConnection(sc, []telnet.Option{telnet.TN3270EOption(telnet.TN3270EResponses)})
+	tn := conn.OptionHandlers[telnet.TN3270E].(*telnet.TN3270EHandler)
+	h, b, err := tn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.SeqNumber != 1 || !bytes.Equal(b, []byte{0x7d}) {
+		t.Errorf("Unexpected message %+v %v", h, b)
+	}
+	select {
+	case <-tn.Ready():
+	default:
+		t.Fatal("Expected negotiation to be complete")
+	}
+	if tn.DeviceType != "IBM-3279-2-E" || tn.DeviceName != "LU02" || !bytes.Equal(tn.Functions, []byte{telnet.TN3270EResponses}) {
+		t.Errorf("Unexpected negotiation result: %q %q %v", tn.DeviceType, tn.DeviceName, tn.Functions)
+	}
+
+	sc.out.Reset()
+	err = tn.WriteMessage(telnet.TN3270EHeader{DataType: telnet.TN3270ETypeResponse, SeqNumber: 1}, []byte{0x00, 0xff})
+	if err != nil {
+		t.Error(err)
+	}
+	expected := []byte{2, 0, 0, 0, 1, 0x00, 0xff, 0xff, 255, 239}
+	if !bytes.Equal(expected, sc.out.Bytes()) {
+		t.Errorf("Expected %v, received %v", expected, sc.out.Bytes())
+	}
+}
+
+func TestServerTN3270EFunctionsCounterProposal(t *testing.T) {
+	sc := newScriptConn(
+		[]byte{255, 251, 40},
+		sb3270(append([]byte{2, 7}, "IBM-3278-2-E"...)...),
+		sb3270(3, 7, 0, 2),
+	)
+	conn := telnet.NewConnection(sc, []telnet.Option{telnet.TN3270EOption(telnet.TN3270EResponses)})
+	conn.Read(make([]byte, 32))
+	out := sc.out.Bytes()
+	if !bytes.HasSuffix(out, sb3270(3, 7, 2)) {
+		t.Errorf("Expected FUNCTIONS REQUEST RESPONSES, received %v", out)
+	}
+	// DEVICE-TYPE IS <type> CONNECT <generated name>
+	if !bytes.Contains(out, append([]byte("IBM-3278-2-E"), 1, 'T', 'N')) {
+		t.Errorf("Expected generated device name, received %v", out)
+	}
+}
+
+func TestClientTN3270EReject(t *testing.T) {
+	sc := newScriptConn(
+		[]byte{255, 253, 40},
+		sb3270(8, 2),
+		sb3270(2, 6, 5, 1), // DEVICE-TYPE REJECT REASON DEVICE-IN-USE
+	)
+	conn := telnet.NewConnection(sc, []telnet.Option{telnet.ExposeTN3270E("IBM-3278-2-E", "LU01")})
+	tn := conn.OptionHandlers[telnet.TN3270E].(*telnet.TN3270EHandler)
+	tn.ReadMessage()
+	<-tn.Ready()
+	rej, ok := tn.Err().(*telnet.TN3270ERejectError)
+	if !ok || rej.Reason != telnet.TN3270EDeviceInUse {
+		t.Errorf("Expected DEVICE-IN-USE rejection, got %v", tn.Err())
+	}
+	if !bytes.HasSuffix(sc.out.Bytes(), []byte{255, 252, 40}) {
+		t.Errorf("Expected IAC WONT TN3270E, received %v", sc.out.Bytes())
+	}
+}
+
+func TestConnection_ReadRecord(t *testing.T) {
+	// Commands other than EOR which take no option are dropped.
+	sc := newScriptConn([]byte("one"), []byte{255, 241}, []byte("!"), []byte{255, 239}, []byte("two"), []byte{255, 239})
+	conn := telnet.NewConnection(sc, nil)
+	for _, expected := range []string{"one!", "two"} {
+		rec, err := conn.ReadRecord()
+		if err != nil {
+			t.Error(err)
+		}
+		if string(rec) != expected {
+			t.Errorf("Expected %q, got %q", expected, rec)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package telnet
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Direction is whether a traced control sequence was sent or received.
+type Direction int
+
+const (
+	Received Direction = iota
+	Sent
+)
+
+func (d Direction) String() string {
+	if d == Sent {
+		return "SENT"
+	}
+	return "RECV"
+}
+
+// Tracer receives every control sequence sent or received on a Connection,
+// for debugging negotiation. Trace is called synchronously from whichever
+// goroutine is reading or writing, so it must be safe for concurrent use and
+// should not block. ev.Body is only valid for the duration of the call.
+type Tracer interface {
+	Trace(c *Connection, dir Direction, ev Event)
+}
+
+// TraceOption installs t as the Connection's Tracer, so that option offers
+// are traced too. It negotiates no option itself.
+func TraceOption(t Tracer) Option {
+	return func(c *Connection) Negotiator {
+		c.tracer = t
+		return nil
+	}
+}
+
+// SetTracer sets the Connection's Tracer, or removes it if t is nil. It must
+// not be called concurrently with reads or writes; to trace a connection from
+// the start, use TraceOption.
+func (c *Connection) SetTracer(t Tracer) {
+	c.tracer = t
+}
+
+func (c *Connection) trace(dir Direction, ev Event) {
+	if c.tracer != nil {
+		c.tracer.Trace(c, dir, ev)
+	}
+}
+
+// traceRaw traces each control sequence in b as sent. Sequences split across
+// calls are not seen, as b is parsed on its own.
+func (c *Connection) traceRaw(b []byte) {
+	var p Parser
+	scratch := make([]byte, len(b))
+	for len(b) > 0 {
+		_, n, ev := p.Parse(scratch, b)
+		b = b[n:]
+		if ev != nil {
+			c.trace(Sent, *ev)
+		}
+	}
+}
+
+var commandNames = map[byte]string{
+	EOR: "EOR", SE: "SE", NOP: "NOP", DM: "DM", BRK: "BRK", IP: "IP",
+	AO: "AO", AYT: "AYT", EC: "EC", EL: "EL", GA: "GA", SB: "SB",
+	WILL: "WILL", WONT: "WONT", DO: "DO", DONT: "DONT", IAC: "IAC",
+}
+
+var optionNames = map[byte]string{
+	ECHO: "ECHO", TM: "TIMING-MARK", LOGOUT: "LOGOUT", SNDLOC: "SNDLOC",
+	TTYPE: "TTYPE", NAWS: "NAWS", TSPEED: "TSPEED", LFLOW: "LFLOW",
+	XDISPLOC: "XDISPLOC", ENCRYPT: "ENCRYPT", TN3270E: "TN3270E",
+	0: "BINARY", 3: "SGA", 5: "STATUS", 25: "EOR", 34: "LINEMODE",
+	36: "OLD-ENVIRON", 39: "NEW-ENVIRON", 42: "CHARSET",
+}
+
+// CommandName returns the name of a telnet command, such as "WILL", or its
+// number if it has none.
+func CommandName(cmd byte) string {
+	if name, ok := commandNames[cmd]; ok {
+		return name
+	}
+	return strconv.Itoa(int(cmd))
+}
+
+// OptionName returns the name of a telnet option, such as "NAWS", or its
+// number if it has none.
+func OptionName(option byte) string {
+	if name, ok := optionNames[option]; ok {
+		return name
+	}
+	return strconv.Itoa(int(option))
+}
+
+// String decodes the event, e.g. "WILL NAWS", "SB TTYPE SEND" or "NOP".
+// Subnegotiation bodies are shown as text where the option exchanges text,
+// and as numbers otherwise.
+func (ev Event) String() string {
+	switch ev.Command {
+	case WILL, WONT, DO, DONT:
+		return CommandName(ev.Command) + " " + OptionName(ev.Option)
+	case SB:
+	default:
+		return CommandName(ev.Command)
+	}
+	var sb strings.Builder
+	sb.WriteString("SB ")
+	sb.WriteString(OptionName(ev.Option))
+	body := ev.Body
+	switch ev.Option {
+	case TTYPE, TSPEED, XDISPLOC:
+		if len(body) > 0 && (body[0] == IS || body[0] == SEND) {
+			if body[0] == IS {
+				sb.WriteString(" IS")
+			} else {
+				sb.WriteString(" SEND")
+			}
+			if len(body) > 1 {
+				fmt.Fprintf(&sb, " %q", body[1:])
+			}
+			body = nil
+		}
+	case SNDLOC:
+		fmt.Fprintf(&sb, " %q", body)
+		body = nil
+	}
+	for _, b := range body {
+		sb.WriteByte(' ')
+		sb.WriteString(strconv.Itoa(int(b)))
+	}
+	if ev.Truncated {
+		sb.WriteString(" (truncated)")
+	}
+	return sb.String()
+}
+
+// SlogTracer is a Tracer which logs each control sequence to a slog.Logger,
+// with a message such as "RECV WILL NAWS" and the connection's remote address.
+type SlogTracer struct {
+	Logger *slog.Logger
+	// Level is the level control sequences are logged at. The zero value is
+	// slog.LevelInfo, so it should usually be set to slog.LevelDebug.
+	Level slog.Level
+}
+
+// NewSlogTracer returns a SlogTracer which logs to l at slog.LevelDebug. If l
+// is nil, slog.Default() is used.
+func NewSlogTracer(l *slog.Logger) *SlogTracer {
+	if l == nil {
+		l = slog.Default()
+	}
+	return &SlogTracer{Logger: l, Level: slog.LevelDebug}
+}
+
+func (t *SlogTracer) Trace(c *Connection, dir Direction, ev Event) {
+	if !t.Logger.Enabled(context.Background(), t.Level) {
+		return
+	}
+	t.Logger.Log(context.Background(), t.Level, dir.String()+" "+ev.String(),
+		slog.String("remote", c.RemoteAddr().String()))
+}
+
+// DumpTracer is a Tracer which writes each control sequence to a Writer, one
+// per line, with a timestamp and the connection's remote address, e.g.
+// "15:04:05.000 192.0.2.1:56324 SENT DO NAWS".
+type DumpTracer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewDumpTracer returns a DumpTracer writing to w.
+func NewDumpTracer(w io.Writer) *DumpTracer {
+	return &DumpTracer{w: w}
+}
+
+func (t *DumpTracer) Trace(c *Connection, dir Direction, ev Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.w, "%s %v %v %v\n", time.Now().Format("15:04:05.000"), c.RemoteAddr(), dir, ev)
+}
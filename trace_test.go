@@ -0,0 +1,107 @@
+package telnet_test
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/aprice/telnet"
+)
+
+func TestEvent_String(t *testing.T) {
+	tests := []struct {
+		ev       telnet.Event
+		expected string
+	}{
+		{telnet.Event{Command: telnet.WILL, Option: telnet.NAWS}, "WILL NAWS"},
+		{telnet.Event{Command: telnet.DONT, Option: 200}, "DONT 200"},
+		{telnet.Event{Command: telnet.NOP}, "NOP"},
+		{telnet.Event{Command: telnet.DM}, "DM"},
+		{telnet.Event{Command: telnet.SB, Option: telnet.TTYPE, Body: []byte{1}}, "SB TTYPE SEND"},
+		{telnet.Event{Command: telnet.SB, Option: telnet.TTYPE, Body: []byte("\x00xterm")}, `SB TTYPE IS "xterm"`},
+		{telnet.Event{Command: telnet.SB, Option: telnet.NAWS, Body: []byte{0, 80, 0, 24}}, "SB NAWS 0 80 0 24"},
+		{telnet.Event{Command: telnet.SB, Option: telnet.NAWS, Body: []byte{0}, Truncated: true}, "SB NAWS 0 (truncated)"},
+	}
+	for _, tt := range tests {
+		if got := tt.ev.String(); got != tt.expected {
+			t.Errorf("Expected %q, got %q", tt.expected, got)
+		}
+	}
+}
+
+// traceNAWS runs a NAWS negotiation on a connection traced by tracer.
+func traceNAWS(t *testing.T, tracer telnet.Tracer) {
+	t.Helper()
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		client.Read(make([]byte, 3))
+		client.Write([]byte{255, 251, 31, 255, 250, 31, 0, 80, 0, 24, 255, 240, 'x'})
+	}()
+	conn := telnet.NewConnection(server, []telnet.Option{telnet.TraceOption(tracer), telnet.NAWSOption})
+	defer conn.Close()
+	if _, err := conn.Read(make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDumpTracer(t *testing.T) {
+	var buf bytes.Buffer
+	traceNAWS(t, telnet.NewDumpTracer(&buf))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	expected := []string{"pipe SENT DO NAWS", "pipe RECV WILL NAWS", "pipe RECV SB NAWS 0 80 0 24"}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d lines, got %q", len(expected), lines)
+	}
+	for i := range expected {
+		if !strings.HasSuffix(lines[i], expected[i]) {
+			t.Errorf("Expected line %d to end %q, got %q", i, expected[i], lines[i])
+		}
+	}
+}
+
+func TestSlogTracer(t *testing.T) {
+	var buf bytes.Buffer
+	l := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	traceNAWS(t, telnet.NewSlogTracer(l))
+	for _, msg := range []string{`msg="SENT DO NAWS"`, `msg="RECV WILL NAWS"`, `msg="RECV SB NAWS 0 80 0 24"`} {
+		if !strings.Contains(buf.String(), msg) {
+			t.Errorf("Expected log to contain %s, got %q", msg, buf.String())
+		}
+	}
+	if !strings.Contains(buf.String(), "level=DEBUG") || !strings.Contains(buf.String(), "remote=pipe") {
+		t.Errorf("Expected debug level and remote address, got %q", buf.String())
+	}
+
+	// Nothing is logged below the logger's level.
+	buf.Reset()
+	l = slog.New(slog.NewTextHandler(&buf, nil))
+	traceNAWS(t, telnet.NewSlogTracer(l))
+	if buf.Len() != 0 {
+		t.Errorf("Expected nothing logged at info level, got %q", buf.String())
+	}
+}
+
+func TestTracer_Writes(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go io.Copy(io.Discard, client)
+	var buf bytes.Buffer
+	conn := telnet.NewConnection(server, []telnet.Option{telnet.TraceOption(telnet.NewDumpTracer(&buf))})
+	defer conn.Close()
+	conn.WriteRecord([]byte("rec"))
+	conn.RawWrite([]byte{'a', 255, 249, 'b', 255, 251, 1})
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	expected := []string{"pipe SENT EOR", "pipe SENT GA", "pipe SENT WILL ECHO"}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d lines, got %q", len(expected), lines)
+	}
+	for i := range expected {
+		if !strings.HasSuffix(lines[i], expected[i]) {
+			t.Errorf("Expected line %d to end %q, got %q", i, expected[i], lines[i])
+		}
+	}
+}